@@ -0,0 +1,229 @@
+// Package bench replays a capture held in memory through pipeline stages
+// built on the pcap package and measures each stage on its own: packet
+// and byte throughput, allocations, and per-packet latency percentiles.
+//
+//	c, err := bench.Load(f)
+//	...
+//	results, err := bench.Run(c, bench.Decode, bench.Stage{
+//		Name: "throughput",
+//		Run:  func(p *pcap.Packet) error { tcp.Add(p); return nil },
+//	})
+//	...
+//	bench.Report(os.Stdout, results)
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/polygon-io/go-lib-pcap"
+)
+
+// Stage is one step of a pipeline, called with every packet in order.
+type Stage struct {
+	Name string
+	Run  func(p *pcap.Packet) error
+}
+
+// Decode is the stage decoding the headers of every packet.
+var Decode = Stage{Name: "decode", Run: func(p *pcap.Packet) error { return p.Decode() }}
+
+// Capture is a capture file loaded into memory.
+type Capture struct {
+	Info    pcap.CaptureInfo
+	raw     []byte
+	records []record
+}
+
+type record struct {
+	hdr  pcap.PacketHeader
+	data []byte
+}
+
+// Load reads a whole capture into memory.
+func Load(r io.Reader) (*Capture, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := pcap.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{Info: pr.Info, raw: raw}
+	for p := pr.Next(); p != nil; p = pr.Next() {
+		c.records = append(c.records, record{
+			hdr:  pcap.PacketHeader{Time: p.Time, Timestamp: p.Timestamp, Caplen: p.Caplen, Len: p.Len},
+			data: append([]byte(nil), p.Data...),
+		})
+		p.Free()
+	}
+	return c, pr.Err()
+}
+
+// Len returns the number of packets of the capture.
+func (c *Capture) Len() int { return len(c.records) }
+
+// packets returns fresh copies of the packets, which stages may modify.
+func (c *Capture) packets() []*pcap.Packet {
+	pkts := make([]*pcap.Packet, len(c.records))
+	for i, rec := range c.records {
+		pkts[i] = &pcap.Packet{
+			Time:      rec.hdr.Time,
+			Timestamp: rec.hdr.Timestamp,
+			Caplen:    rec.hdr.Caplen,
+			Len:       rec.hdr.Len,
+			Data:      append([]byte(nil), rec.data...),
+		}
+	}
+	return pkts
+}
+
+// Result is the measurement of one stage over the whole capture.
+type Result struct {
+	Stage      string
+	Packets    int
+	Bytes      int64 // captured bytes of the packets
+	Duration   time.Duration
+	Allocs     uint64 // heap allocations made by the stage
+	AllocBytes uint64
+
+	P50, P90, P99, Max time.Duration // per-packet latency
+}
+
+// PacketsPerSec returns the packet throughput of the stage.
+func (r *Result) PacketsPerSec() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Packets) / r.Duration.Seconds()
+}
+
+// MBPerSec returns the byte throughput of the stage, in 10^6 bytes per
+// second.
+func (r *Result) MBPerSec() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / 1e6 / r.Duration.Seconds()
+}
+
+func (r *Result) perPacket(v uint64) uint64 {
+	if r.Packets == 0 {
+		return 0
+	}
+	return v / uint64(r.Packets)
+}
+
+// String formats the result like a benchmark of go test, with one
+// operation per packet, so that results can be compared with benchstat.
+func (r *Result) String() string {
+	nsPerOp := 0.0
+	if r.Packets > 0 {
+		nsPerOp = float64(r.Duration.Nanoseconds()) / float64(r.Packets)
+	}
+	name := strings.Replace(r.Stage, " ", "_", -1)
+	if name != "" {
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+	return fmt.Sprintf("Benchmark%s\t%d\t%.1f ns/op\t%.2f MB/s\t%d B/op\t%d allocs/op",
+		name, r.Packets, nsPerOp, r.MBPerSec(), r.perPacket(r.AllocBytes), r.perPacket(r.Allocs))
+}
+
+// measure times every call of fn and records the allocations of the whole
+// run. The latency of very fast stages includes the cost of reading the
+// clock, some tens of nanoseconds.
+func measure(name string, n int, size func(i int) int, fn func(i int) error) (Result, error) {
+	res := Result{Stage: name, Packets: n}
+	lat := make([]time.Duration, n)
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		t := time.Now()
+		if err := fn(i); err != nil {
+			return res, fmt.Errorf("bench: stage %s, packet %d: %v", name, i+1, err)
+		}
+		lat[i] = time.Since(t)
+	}
+	res.Duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	res.Allocs = after.Mallocs - before.Mallocs
+	res.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	for i := 0; i < n; i++ {
+		res.Bytes += int64(size(i))
+	}
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	if n > 0 {
+		res.P50 = lat[n*50/100]
+		res.P90 = lat[n*90/100]
+		res.P99 = lat[n*99/100]
+		res.Max = lat[n-1]
+	}
+	return res, nil
+}
+
+// Run measures reading the capture with a pcap.Reader, reported as stage
+// "read", and then each stage in turn. Every stage gets fresh packets that
+// went through the stages before it unmeasured, so its allocations and
+// latencies are its own.
+func Run(c *Capture, stages ...Stage) ([]Result, error) {
+	var results []Result
+
+	r, err := pcap.NewReader(bytes.NewReader(c.raw))
+	if err != nil {
+		return nil, err
+	}
+	read, err := measure("read", len(c.records), func(i int) int { return len(c.records[i].data) },
+		func(i int) error {
+			p := r.Next()
+			if p == nil {
+				return fmt.Errorf("capture ended: %v", r.Err())
+			}
+			p.Free()
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, read)
+
+	for n, stage := range stages {
+		pkts := c.packets()
+		for _, prev := range stages[:n] {
+			for i, p := range pkts {
+				if err := prev.Run(p); err != nil {
+					return results, fmt.Errorf("bench: stage %s, packet %d: %v", prev.Name, i+1, err)
+				}
+			}
+		}
+		res, err := measure(stage.Name, len(pkts), func(i int) int { return len(pkts[i].Data) },
+			func(i int) error { return stage.Run(pkts[i]) })
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Report writes the results as a table.
+func Report(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tpackets\tpkt/s\tMB/s\tallocs/pkt\tB/pkt\tp50\tp90\tp99\tmax\t")
+	for i := range results {
+		r := &results[i]
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t%.1f\t%d\t%d\t%v\t%v\t%v\t%v\t\n",
+			r.Stage, r.Packets, r.PacketsPerSec(), r.MBPerSec(),
+			r.perPacket(r.Allocs), r.perPacket(r.AllocBytes), r.P50, r.P90, r.P99, r.Max)
+	}
+	return tw.Flush()
+}
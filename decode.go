@@ -1,6 +1,7 @@
 package pcap
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 	"strings"
@@ -11,10 +12,16 @@ const (
 	TYPE_ARP = 0x0806
 	TYPE_IP6 = 0x86DD
 
+	TYPE_EAPOL  = 0x888E
+	TYPE_MACSEC = 0x88E5
+
 	IP_ICMP = 1
 	IP_INIP = 4
 	IP_TCP  = 6
 	IP_UDP  = 17
+	IP_IPV6 = 41
+	IP_VRRP = 112
+	IP_SCTP = 132
 )
 
 // Port from sf-pcap.c file.
@@ -143,6 +150,8 @@ type Iphdr struct {
 	Checksum   uint16
 	SrcIp      []byte
 	DestIp     []byte
+
+	raw []byte // header bytes within Packet.Data
 }
 
 func (ip *Iphdr) SrcAddr() string  { return net.IP(ip.SrcIp).String() }
@@ -159,9 +168,77 @@ type Tcphdr struct {
 	Window     uint16
 	Checksum   uint16
 	Urgent     uint16
+	Options    []byte
 	Data       []byte
 }
 
+// TCP option kinds, RFC 793, RFC 2018 and RFC 7323.
+const (
+	TCPOPT_EOL            = 0
+	TCPOPT_NOP            = 1
+	TCPOPT_MSS            = 2
+	TCPOPT_WSCALE         = 3
+	TCPOPT_SACK_PERMITTED = 4
+	TCPOPT_SACK           = 5
+	TCPOPT_TIMESTAMP      = 8
+)
+
+// SackBlock is one block of a TCP selective acknowledgement option.
+type SackBlock struct {
+	Left  uint32 // first sequence number of the block
+	Right uint32 // sequence number following the block
+}
+
+// option returns the value of the first TCP option of the given kind.
+func (tcp *Tcphdr) option(kind uint8) ([]byte, bool) {
+	opts := tcp.Options
+	for len(opts) > 0 {
+		switch opts[0] {
+		case TCPOPT_EOL:
+			return nil, false
+		case TCPOPT_NOP:
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+			return nil, false
+		}
+		if opts[0] == kind {
+			return opts[2:opts[1]], true
+		}
+		opts = opts[opts[1]:]
+	}
+	return nil, false
+}
+
+// WindowScale returns the shift count of the window scale option.
+func (tcp *Tcphdr) WindowScale() (uint8, bool) {
+	v, ok := tcp.option(TCPOPT_WSCALE)
+	if !ok || len(v) != 1 {
+		return 0, false
+	}
+	if v[0] > 14 {
+		return 14, true
+	}
+	return v[0], true
+}
+
+// Sack returns the blocks of the selective acknowledgement option.
+func (tcp *Tcphdr) Sack() []SackBlock {
+	v, ok := tcp.option(TCPOPT_SACK)
+	if !ok {
+		return nil
+	}
+	blocks := make([]SackBlock, 0, len(v)/8)
+	for ; len(v) >= 8; v = v[8:] {
+		blocks = append(blocks, SackBlock{
+			Left:  binary.BigEndian.Uint32(v[0:4]),
+			Right: binary.BigEndian.Uint32(v[4:8]),
+		})
+	}
+	return blocks
+}
+
 const (
 	TCP_FIN = 1 << iota
 	TCP_SYN
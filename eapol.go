@@ -0,0 +1,185 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EAPOL packet types, IEEE 802.1X.
+const (
+	EAPOL_EAP    = 0
+	EAPOL_START  = 1
+	EAPOL_LOGOFF = 2
+	EAPOL_KEY    = 3
+	EAPOL_ALERT  = 4
+	EAPOL_MKA    = 5
+)
+
+// EAP codes and common method types, RFC 3748.
+const (
+	EAP_REQUEST  = 1
+	EAP_RESPONSE = 2
+	EAP_SUCCESS  = 3
+	EAP_FAILURE  = 4
+
+	EAP_TYPE_IDENTITY = 1
+	EAP_TYPE_MD5      = 4
+	EAP_TYPE_TLS      = 13
+	EAP_TYPE_TTLS     = 21
+	EAP_TYPE_PEAP     = 25
+)
+
+var eapolTypes = [...]string{"EAP", "Start", "Logoff", "Key", "Alert", "MKA"}
+var eapCodes = [...]string{"", "Request", "Response", "Success", "Failure"}
+
+// Eapolhdr is an EAP over LAN header. The Eap fields are only set for
+// EAPOL_EAP packets.
+type Eapolhdr struct {
+	Version uint8
+	Type    uint8 // see EAPOL_*
+	Length  uint16
+	Body    []byte
+
+	EapCode   uint8 // see EAP_*
+	EapId     uint8
+	EapLength uint16
+	EapType   uint8 // requests and responses only
+	EapData   []byte
+}
+
+func (e *Eapolhdr) String() string {
+	typ := fmt.Sprintf("type=%d", e.Type)
+	if int(e.Type) < len(eapolTypes) {
+		typ = eapolTypes[e.Type]
+	}
+	if e.Type != EAPOL_EAP {
+		return fmt.Sprintf("EAPOL v%d %s LEN=%d", e.Version, typ, e.Length)
+	}
+	code := fmt.Sprintf("code=%d", e.EapCode)
+	if e.EapCode > 0 && int(e.EapCode) < len(eapCodes) {
+		code = eapCodes[e.EapCode]
+	}
+	s := fmt.Sprintf("EAPOL v%d EAP %s ID=%d", e.Version, code, e.EapId)
+	if e.EapCode == EAP_REQUEST || e.EapCode == EAP_RESPONSE {
+		s += fmt.Sprintf(" TYPE=%d", e.EapType)
+		if e.EapType == EAP_TYPE_IDENTITY {
+			s += fmt.Sprintf(" IDENTITY=%q", e.EapData)
+		}
+	}
+	return s
+}
+
+func (p *Packet) decodeEapol() {
+	pkt := p.Payload
+	if len(pkt) < 4 {
+		return
+	}
+	e := &Eapolhdr{
+		Version: pkt[0],
+		Type:    pkt[1],
+		Length:  binary.BigEndian.Uint16(pkt[2:4]),
+	}
+	end := 4 + int(e.Length)
+	if end > len(pkt) {
+		end = len(pkt)
+	}
+	e.Body = pkt[4:end]
+	p.Eapolhdr = e
+	if e.Type != EAPOL_EAP || len(e.Body) < 4 {
+		return
+	}
+	e.EapCode = e.Body[0]
+	e.EapId = e.Body[1]
+	e.EapLength = binary.BigEndian.Uint16(e.Body[2:4])
+	eap := e.Body
+	if int(e.EapLength) < len(eap) {
+		eap = eap[:e.EapLength]
+	}
+	if (e.EapCode == EAP_REQUEST || e.EapCode == EAP_RESPONSE) && len(eap) >= 5 {
+		e.EapType = eap[4]
+		e.EapData = eap[5:]
+	}
+}
+
+// MACsec SecTAG TCI bits, IEEE 802.1AE.
+const (
+	MACSEC_TCI_V   = 0x80 // version, always 0
+	MACSEC_TCI_ES  = 0x40 // end station
+	MACSEC_TCI_SC  = 0x20 // SCI present
+	MACSEC_TCI_SCB = 0x10 // single copy broadcast
+	MACSEC_TCI_E   = 0x08 // encrypted
+	MACSEC_TCI_C   = 0x04 // changed text
+)
+
+// MACSEC_ICV_LEN is the length of the integrity check value of the default
+// GCM-AES cipher suites.
+const MACSEC_ICV_LEN = 16
+
+// Macsechdr is a MACsec security tag.
+type Macsechdr struct {
+	TciAn        uint8 // TCI bits, see MACSEC_TCI_*, and association number
+	ShortLength  uint8
+	PacketNumber uint32
+	Sci          uint64 // secure channel identifier, if MACSEC_TCI_SC is set
+	Icv          []byte
+}
+
+// Encrypted reports whether the user data is confidentiality protected.
+func (m *Macsechdr) Encrypted() bool { return m.TciAn&(MACSEC_TCI_E|MACSEC_TCI_C) != 0 }
+
+// AssociationNumber returns the secure association number.
+func (m *Macsechdr) AssociationNumber() uint8 { return m.TciAn & 0x03 }
+
+// HasSci reports whether the tag carries an explicit SCI.
+func (m *Macsechdr) HasSci() bool { return m.TciAn&MACSEC_TCI_SC != 0 }
+
+func (m *Macsechdr) String() string {
+	s := fmt.Sprintf("MACsec AN=%d PN=%d", m.AssociationNumber(), m.PacketNumber)
+	if m.HasSci() {
+		s += fmt.Sprintf(" SCI=%016x", m.Sci)
+	}
+	if m.Encrypted() {
+		s += " encrypted"
+	}
+	return s
+}
+
+// decodeMacsec decodes the SecTAG and, for integrity-only frames, carries
+// on with the inner EtherType.
+func (p *Packet) decodeMacsec() {
+	pkt := p.Payload
+	if len(pkt) < 6 {
+		return
+	}
+	m := &Macsechdr{
+		TciAn:        pkt[0],
+		ShortLength:  pkt[1] & 0x3F,
+		PacketNumber: binary.BigEndian.Uint32(pkt[2:6]),
+	}
+	pkt = pkt[6:]
+	if m.HasSci() {
+		if len(pkt) < 8 {
+			return
+		}
+		m.Sci = binary.BigEndian.Uint64(pkt[0:8])
+		pkt = pkt[8:]
+	}
+	// A short length is set for user data shorter than 48 bytes, when
+	// the frame may have been padded.
+	if m.ShortLength != 0 && int(m.ShortLength)+MACSEC_ICV_LEN < len(pkt) {
+		pkt = pkt[:int(m.ShortLength)+MACSEC_ICV_LEN]
+	}
+	if len(pkt) < MACSEC_ICV_LEN {
+		return
+	}
+	m.Icv = pkt[len(pkt)-MACSEC_ICV_LEN:]
+	pkt = pkt[:len(pkt)-MACSEC_ICV_LEN]
+	p.Macsechdr = m
+	p.Payload = pkt
+	if m.Encrypted() || len(pkt) < 2 {
+		return
+	}
+	p.Type = int(binary.BigEndian.Uint16(pkt[0:2]))
+	p.Payload = pkt[2:]
+	p.decodeEthertype()
+}
@@ -0,0 +1,123 @@
+package pcap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventKind classifies the events published on an EventBus.
+type EventKind int
+
+const (
+	EVENT_DECODE_ERROR EventKind = iota + 1 // malformed or truncated data
+	EVENT_DROP                              // data discarded over a limit
+	EVENT_GAP                               // data missing from the capture
+	EVENT_ROTATION                          // an output file was closed and replaced
+	EVENT_FILTER_MATCH                      // a packet matched a filter
+	EVENT_SEGMENT                           // a concatenated file started
+)
+
+var eventNames = map[EventKind]string{
+	EVENT_DECODE_ERROR: "decode-error",
+	EVENT_DROP:         "drop",
+	EVENT_GAP:          "gap",
+	EVENT_ROTATION:     "rotation",
+	EVENT_FILTER_MATCH: "filter-match",
+	EVENT_SEGMENT:      "segment",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("event-%d", int(k))
+}
+
+// Event is something a component wants its operators to know about.
+type Event struct {
+	Kind    EventKind
+	Source  string    // publishing component, e.g. "reader"
+	Time    time.Time // capture time of the packet concerned, if any
+	Packet  int       // number of the packet concerned from 1, if known
+	Count   int64     // bytes or items concerned, if any
+	Message string
+	Err     error
+}
+
+func (e *Event) String() string {
+	s := fmt.Sprintf("%s %s", e.Source, e.Kind)
+	if e.Packet > 0 {
+		s += fmt.Sprintf(" packet=%d", e.Packet)
+	}
+	if e.Count != 0 {
+		s += fmt.Sprintf(" count=%d", e.Count)
+	}
+	if e.Message != "" {
+		s += " " + e.Message
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// EventBus delivers events from the components that publish them to the
+// callers that subscribe. Subscribers are called synchronously, in the
+// publishing goroutine, and must not block. A nil *EventBus discards
+// events, so components publish without checking whether a bus is set.
+type EventBus struct {
+	mu   sync.Mutex
+	subs []*subscription // in subscription order; replaced, never modified
+}
+
+type subscription struct {
+	fn    func(*Event)
+	kinds map[EventKind]bool // nil for every kind
+}
+
+// NewEventBus creates a bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe calls fn for every event of the given kinds, or of any kind if
+// none are given, until the returned function is called.
+func (b *EventBus) Subscribe(fn func(*Event), kinds ...EventKind) (cancel func()) {
+	s := &subscription{fn: fn}
+	if len(kinds) > 0 {
+		s.kinds = make(map[EventKind]bool, len(kinds))
+		for _, k := range kinds {
+			s.kinds[k] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs[:len(b.subs):len(b.subs)], s)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, sub := range b.subs {
+			if sub == s {
+				subs := make([]*subscription, 0, len(b.subs)-1)
+				b.subs = append(append(subs, b.subs[:i]...), b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish delivers e to the subscribers of its kind.
+func (b *EventBus) Publish(e *Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, s := range subs {
+		if s.kinds == nil || s.kinds[e.Kind] {
+			s.fn(e)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package extcap lets packet sources built on the pcap package appear as
+// capture interfaces in Wireshark, using its extcap protocol.
+// https://www.wireshark.org/docs/wsdg_html_chunked/ChCaptureExtcap.html
+//
+// A bridge is a small program installed in Wireshark's extcap directory:
+//
+//	func main() {
+//		extcap.Main("1.0", &extcap.Interface{
+//			Value:   "feed",
+//			Display: "Decapsulated market data feed",
+//			Args:    []extcap.Arg{{Call: "--topic", Display: "Topic"}},
+//			Open: func(args map[string]string) (extcap.Source, error) {
+//				return openFeed(args["topic"])
+//			},
+//		})
+//	}
+package extcap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/polygon-io/go-lib-pcap"
+)
+
+// Source delivers packets to Wireshark. A *pcap.Reader is a Source. A
+// Source that is also an io.Closer is closed when Wireshark stops the
+// capture, which should make a blocked Next return nil.
+type Source interface {
+	// Next returns the next packet or nil when the source is done.
+	Next() *pcap.Packet
+	// Err returns the error that stopped Next, if any.
+	Err() error
+}
+
+// Arg is a configuration option shown by Wireshark before a capture starts.
+type Arg struct {
+	Call     string // command line flag, e.g. "--topic"
+	Display  string
+	Type     string // extcap argument type, "string" if empty
+	Default  string
+	Tooltip  string
+	Required bool
+}
+
+// Interface is a capture interface offered to Wireshark.
+type Interface struct {
+	Value   string // name passed back in --extcap-interface
+	Display string
+
+	LinkType uint32 // pcap.LINKTYPE_*, Ethernet if zero
+	DltName  string // DLT name shown by Wireshark, "EN10MB" if empty
+	SnapLen  uint32 // 262144 if zero
+	Args     []Arg
+
+	// Open starts the source. Args holds the values of Args keyed by Call
+	// without its leading dashes, and the capture filter entered in
+	// Wireshark under "extcap-capture-filter".
+	Open func(args map[string]string) (Source, error)
+}
+
+func (i *Interface) linkType() uint32 {
+	if i.LinkType == 0 {
+		return pcap.LINKTYPE_ETHERNET
+	}
+	return i.LinkType
+}
+
+// Main runs Run with the program's arguments and exits. A capture is
+// stopped by Wireshark with SIGTERM, or by closing the fifo. On SIGTERM,
+// the packet being written is completed and flushed before Main exits.
+func Main(version string, ifaces ...*Interface) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	if err := run(os.Args[1:], os.Stdout, version, stop, ifaces...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Run executes one extcap invocation. Listings are written to out.
+func Run(args []string, out io.Writer, version string, ifaces ...*Interface) error {
+	return run(args, out, version, nil, ifaces...)
+}
+
+// run is Run, with a capture that stops once stop is closed.
+func run(args []string, out io.Writer, version string, stop <-chan struct{}, ifaces ...*Interface) error {
+	opts, err := parseArgs(args, ifaces)
+	if err != nil {
+		return err
+	}
+	if _, ok := opts["extcap-interfaces"]; ok {
+		fmt.Fprintf(out, "extcap {version=%s}\n", version)
+		for _, i := range ifaces {
+			fmt.Fprintf(out, "interface {value=%s}{display=%s}\n", i.Value, i.Display)
+		}
+		return nil
+	}
+	iface, err := lookup(ifaces, opts["extcap-interface"])
+	if err != nil {
+		return err
+	}
+	if _, ok := opts["extcap-dlts"]; ok {
+		name := iface.DltName
+		if name == "" {
+			name = "EN10MB"
+		}
+		fmt.Fprintf(out, "dlt {number=%d}{name=%s}{display=%s}\n", iface.linkType(), name, iface.Display)
+		return nil
+	}
+	if _, ok := opts["extcap-config"]; ok {
+		for n, a := range iface.Args {
+			typ := a.Type
+			if typ == "" {
+				typ = "string"
+			}
+			fmt.Fprintf(out, "arg {number=%d}{call=%s}{display=%s}{type=%s}", n, a.Call, a.Display, typ)
+			if a.Default != "" {
+				fmt.Fprintf(out, "{default=%s}", a.Default)
+			}
+			if a.Tooltip != "" {
+				fmt.Fprintf(out, "{tooltip=%s}", a.Tooltip)
+			}
+			if a.Required {
+				fmt.Fprint(out, "{required=true}")
+			}
+			fmt.Fprintln(out)
+		}
+		return nil
+	}
+	if _, ok := opts["capture"]; ok {
+		fifo := opts["fifo"]
+		if fifo == "" {
+			return errors.New("extcap: --fifo is required")
+		}
+		return capture(iface, fifo, opts, stop)
+	}
+	return errors.New("extcap: no operation requested")
+}
+
+func lookup(ifaces []*Interface, name string) (*Interface, error) {
+	for _, i := range ifaces {
+		if i.Value == name {
+			return i, nil
+		}
+	}
+	return nil, fmt.Errorf("extcap: unknown interface %q", name)
+}
+
+// flagArgs are the extcap flags that take no value.
+var flagArgs = map[string]bool{
+	"extcap-interfaces": true,
+	"extcap-dlts":       true,
+	"extcap-config":     true,
+	"capture":           true,
+}
+
+// parseArgs collects "--name value" and "--name=value" options. Options of
+// type boolflag and the extcap operations take no value.
+func parseArgs(args []string, ifaces []*Interface) (map[string]string, error) {
+	noValue := make(map[string]bool)
+	for k := range flagArgs {
+		noValue[k] = true
+	}
+	for _, i := range ifaces {
+		for _, a := range i.Args {
+			if a.Type == "boolflag" {
+				noValue[strings.TrimLeft(a.Call, "-")] = true
+			}
+		}
+	}
+	opts := make(map[string]string)
+	for n := 0; n < len(args); n++ {
+		arg := args[n]
+		if !strings.HasPrefix(arg, "--") {
+			return nil, fmt.Errorf("extcap: unexpected argument %q", arg)
+		}
+		name := arg[2:]
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			opts[name[:j]] = name[j+1:]
+			continue
+		}
+		if noValue[name] || n+1 == len(args) || strings.HasPrefix(args[n+1], "--") {
+			opts[name] = ""
+			continue
+		}
+		opts[name] = args[n+1]
+		n++
+	}
+	return opts, nil
+}
+
+func capture(iface *Interface, fifo string, opts map[string]string, stop <-chan struct{}) error {
+	src, err := iface.Open(opts)
+	if err != nil {
+		return err
+	}
+	if c, ok := src.(io.Closer); ok {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-stop:
+				c.Close()
+			case <-done:
+			}
+		}()
+	}
+	f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	snapLen := iface.SnapLen
+	if snapLen == 0 {
+		snapLen = 262144
+	}
+	w, err := pcap.NewStreamWriter(f, &pcap.FileHeader{
+		MagicNumber:  pcap.NSEC_TCPDUMP_MAGIC,
+		VersionMajor: 2,
+		VersionMinor: 4,
+		SnapLen:      snapLen,
+		LinkType:     iface.linkType(),
+	})
+	if err != nil {
+		return err
+	}
+	for pkt := src.Next(); pkt != nil; pkt = src.Next() {
+		err := w.Write(pkt)
+		pkt.Free()
+		if err == pcap.ErrBrokenPipe {
+			// Wireshark stopped reading.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if stopped(stop) {
+			return nil
+		}
+	}
+	if stopped(stop) {
+		// The error, if any, comes from closing the source.
+		return nil
+	}
+	return src.Err()
+}
+
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,117 @@
+package extcap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/polygon-io/go-lib-pcap"
+)
+
+// testSource returns count packets, then blocks in Next until closed if
+// block is set, closing waiting first.
+type testSource struct {
+	count   int
+	block   bool
+	next    func(n int) // called before the packet n is returned
+	n       int
+	once    sync.Once
+	waiting chan struct{}
+	closed  chan struct{}
+}
+
+func (s *testSource) Next() *pcap.Packet {
+	if s.n == s.count {
+		if s.block {
+			close(s.waiting)
+			<-s.closed
+		}
+		return nil
+	}
+	s.n++
+	if s.next != nil {
+		s.next(s.n)
+	}
+	data := []byte{byte(s.n), 0, 0, 0}
+	return &pcap.Packet{Time: time.Unix(int64(s.n), 0), Caplen: 4, Len: 4, Data: data}
+}
+
+func (s *testSource) Err() error { return nil }
+
+func (s *testSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestCaptureStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		block   bool
+		stopAt  int // packet before which the capture is stopped, 0 for none
+		packets int
+	}{
+		{"runs to the end", 3, false, 0, 3},
+		{"stops after the current packet", 5, false, 2, 2},
+		{"unblocks a waiting source", 2, true, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "extcap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			defer os.Remove(f.Name())
+
+			stop := make(chan struct{})
+			src := &testSource{
+				count:   tt.count,
+				block:   tt.block,
+				waiting: make(chan struct{}),
+				closed:  make(chan struct{}),
+			}
+			if tt.stopAt > 0 {
+				src.next = func(n int) {
+					if n == tt.stopAt {
+						close(stop)
+					}
+				}
+			}
+			iface := &Interface{Value: "test", Open: func(map[string]string) (Source, error) { return src, nil }}
+			done := make(chan error, 1)
+			go func() { done <- capture(iface, f.Name(), nil, stop) }()
+			if tt.block {
+				<-src.waiting
+				close(stop)
+			}
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("capture did not stop")
+			}
+
+			data, err := ioutil.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := pcap.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for p := r.Next(); p != nil; p = r.Next() {
+				n++
+			}
+			if n != tt.packets {
+				t.Errorf("wrote %d packets, want %d", n, tt.packets)
+			}
+		})
+	}
+}
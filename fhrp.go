@@ -0,0 +1,162 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Vrrphdr is a VRRP advertisement, RFC 3768 (version 2) and RFC 5798
+// (version 3). Virtual addresses are 16 bytes long when the advertisement
+// is carried over IPv6, 4 bytes otherwise.
+type Vrrphdr struct {
+	Version      uint8
+	Type         uint8 // 1 for advertisements
+	VirtualRtrId uint8
+	Priority     uint8 // 255 for the address owner, 0 when the master resigns
+	CountIpAddrs uint8
+	AuthType     uint8  // version 2 only
+	AdverInt     uint16 // seconds in version 2, centiseconds in version 3
+	Checksum     uint16
+	IpAddrs      [][]byte
+}
+
+// AdvertInterval returns the advertisement interval.
+func (v *Vrrphdr) AdvertInterval() time.Duration {
+	if v.Version >= 3 {
+		return time.Duration(v.AdverInt) * 10 * time.Millisecond
+	}
+	return time.Duration(v.AdverInt) * time.Second
+}
+
+func (v *Vrrphdr) String() string {
+	addrs := make([]string, len(v.IpAddrs))
+	for i, a := range v.IpAddrs {
+		addrs[i] = net.IP(a).String()
+	}
+	return fmt.Sprintf("VRRPv%d VRID=%d PRIO=%d INT=%v ADDRS=%v",
+		v.Version, v.VirtualRtrId, v.Priority, v.AdvertInterval(), addrs)
+}
+
+func (p *Packet) decodeVrrp() {
+	pkt := p.Payload
+	if len(pkt) < 8 {
+		return
+	}
+	v := &Vrrphdr{
+		Version:      pkt[0] >> 4,
+		Type:         pkt[0] & 0x0F,
+		VirtualRtrId: pkt[1],
+		Priority:     pkt[2],
+		CountIpAddrs: pkt[3],
+		Checksum:     binary.BigEndian.Uint16(pkt[6:8]),
+	}
+	if v.Version >= 3 {
+		v.AdverInt = binary.BigEndian.Uint16(pkt[4:6]) & 0x0FFF
+	} else {
+		v.AuthType = pkt[4]
+		v.AdverInt = uint16(pkt[5])
+	}
+	n := 4
+	if p.Ip6hdr.Version == 6 {
+		n = 16
+	}
+	addrs := pkt[8:]
+	for i := 0; i < int(v.CountIpAddrs) && len(addrs) >= n; i++ {
+		v.IpAddrs = append(v.IpAddrs, addrs[:n])
+		addrs = addrs[n:]
+	}
+	p.Vrrphdr = v
+}
+
+// HSRP_PORT is the UDP port of HSRP version 1 and of version 2 over IPv4.
+const HSRP_PORT = 1985
+
+// HSRP op codes.
+const (
+	HSRP_HELLO     = 0
+	HSRP_COUP      = 1
+	HSRP_RESIGN    = 2
+	HSRP_ADVERTISE = 3
+)
+
+var hsrpV1States = map[uint8]string{
+	0: "Initial", 1: "Learn", 2: "Listen", 4: "Speak", 8: "Standby", 16: "Active",
+}
+
+var hsrpV2States = map[uint8]string{
+	1: "Initial", 2: "Learn", 3: "Listen", 4: "Speak", 5: "Standby", 6: "Active",
+}
+
+// Hsrphdr is an HSRP message, RFC 2281 (version 1) or the group state TLV
+// of Cisco HSRP version 2. Version 2 packets may carry several groups; only
+// the first one is decoded.
+type Hsrphdr struct {
+	Version    uint8 // 1 or 2; version 1 sends 0 on the wire
+	OpCode     uint8 // see HSRP_*
+	State      uint8 // version specific, see StateString
+	Group      uint16
+	Priority   uint32
+	HelloTime  time.Duration
+	HoldTime   time.Duration
+	Identifier uint64 // virtual MAC of the sender, version 2 only
+	Auth       []byte // version 1 only
+	VirtualIp  []byte
+}
+
+// StateString returns the name of the router state.
+func (h *Hsrphdr) StateString() string {
+	states := hsrpV1States
+	if h.Version >= 2 {
+		states = hsrpV2States
+	}
+	if s, ok := states[h.State]; ok {
+		return s
+	}
+	return fmt.Sprintf("state=%d", h.State)
+}
+
+func (h *Hsrphdr) String() string {
+	return fmt.Sprintf("HSRPv%d GROUP=%d %s PRIO=%d HELLO=%v HOLD=%v VIP=%s",
+		h.Version, h.Group, h.StateString(), h.Priority, h.HelloTime, h.HoldTime,
+		net.IP(h.VirtualIp))
+}
+
+func (p *Packet) decodeHsrp() {
+	pkt := p.Payload
+	if len(pkt) >= 42 && pkt[0] == 1 && pkt[1] == 40 {
+		// Version 2 group state TLV.
+		t := pkt[2:42]
+		h := &Hsrphdr{
+			Version:    t[0],
+			OpCode:     t[1],
+			State:      t[2],
+			Group:      binary.BigEndian.Uint16(t[4:6]),
+			Identifier: uint64(binary.BigEndian.Uint16(t[6:8]))<<32 | uint64(binary.BigEndian.Uint32(t[8:12])),
+			Priority:   binary.BigEndian.Uint32(t[12:16]),
+			HelloTime:  time.Duration(binary.BigEndian.Uint32(t[16:20])) * time.Millisecond,
+			HoldTime:   time.Duration(binary.BigEndian.Uint32(t[20:24])) * time.Millisecond,
+			VirtualIp:  t[24:40],
+		}
+		if t[3] == 4 {
+			h.VirtualIp = t[24:28]
+		}
+		p.Hsrphdr = h
+		return
+	}
+	if len(pkt) < 20 || pkt[0] != 0 {
+		return
+	}
+	p.Hsrphdr = &Hsrphdr{
+		Version:   1,
+		OpCode:    pkt[1],
+		State:     pkt[2],
+		HelloTime: time.Duration(pkt[3]) * time.Second,
+		HoldTime:  time.Duration(pkt[4]) * time.Second,
+		Priority:  uint32(pkt[5]),
+		Group:     uint16(pkt[6]),
+		Auth:      pkt[8:16],
+		VirtualIp: pkt[16:20],
+	}
+}
@@ -0,0 +1,223 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrShortFrame   = errors.New("pcap: short frame")
+	ErrFrameTooLong = errors.New("pcap: frame too long")
+)
+
+// FrameSpec describes a length-prefixed framing, as used by most binary
+// exchange protocols. Each frame starts with Offset bytes, followed by a
+// length field of Size bytes.
+type FrameSpec struct {
+	Offset int              // bytes preceding the length field
+	Size   int              // width of the length field: 1, 2, 4 or 8
+	Order  binary.ByteOrder // byte order of the length field, big endian if nil
+
+	// IncludesHeader is set when the length counts the bytes up to and
+	// including the length field itself; otherwise it counts only the
+	// bytes that follow it.
+	IncludesHeader bool
+	Adjust         int // added to the length value, for protocols that count oddly
+
+	MaxFrame int // longest frame accepted, 64KiB if zero
+}
+
+func (s *FrameSpec) validate() error {
+	switch s.Size {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("pcap: bad length field size: %d", s.Size)
+	}
+	if s.Offset < 0 {
+		return fmt.Errorf("pcap: bad length field offset: %d", s.Offset)
+	}
+	return nil
+}
+
+func (s *FrameSpec) headerLen() int { return s.Offset + s.Size }
+
+func (s *FrameSpec) maxFrame() int {
+	if s.MaxFrame > 0 {
+		return s.MaxFrame
+	}
+	return 64 << 10
+}
+
+// Len returns the length of the frame at the start of data. It returns
+// ErrShortFrame when data does not hold the complete length field.
+func (s *FrameSpec) Len(data []byte) (int, error) {
+	if err := s.validate(); err != nil {
+		return 0, err
+	}
+	hl := s.headerLen()
+	if len(data) < hl {
+		return 0, ErrShortFrame
+	}
+	order := s.Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	field := data[s.Offset:hl]
+	var v uint64
+	switch s.Size {
+	case 1:
+		v = uint64(field[0])
+	case 2:
+		v = uint64(order.Uint16(field))
+	case 4:
+		v = uint64(order.Uint32(field))
+	case 8:
+		v = order.Uint64(field)
+	}
+	if v > uint64(s.maxFrame()) {
+		return 0, ErrFrameTooLong
+	}
+	n := int(v) + s.Adjust
+	if !s.IncludesHeader {
+		n += hl
+	}
+	if n < hl {
+		return 0, fmt.Errorf("pcap: bad frame length: %d", n)
+	}
+	if n > s.maxFrame() {
+		return 0, ErrFrameTooLong
+	}
+	return n, nil
+}
+
+// Split divides a datagram payload into frames. The frames share the
+// payload's memory. If the payload ends with an incomplete frame, the
+// complete frames are returned together with ErrShortFrame.
+func (s *FrameSpec) Split(payload []byte) ([][]byte, error) {
+	var frames [][]byte
+	for len(payload) > 0 {
+		n, err := s.Len(payload)
+		if err != nil {
+			return frames, err
+		}
+		if n > len(payload) {
+			return frames, ErrShortFrame
+		}
+		frames = append(frames, payload[:n])
+		payload = payload[n:]
+	}
+	return frames, nil
+}
+
+// FrameReader reads frames from a byte stream, such as a TCP connection
+// read back from a file or socket. Streams reassembled from packets by
+// TcpStreams are written rather than read; use FrameBuffer for them.
+type FrameReader struct {
+	r    io.Reader
+	spec FrameSpec
+	buf  []byte
+}
+
+// NewFrameReader creates a FrameReader that reads frames from r.
+func NewFrameReader(r io.Reader, spec FrameSpec) (*FrameReader, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	size := 4096
+	if hl := spec.headerLen(); hl > size {
+		size = hl
+	}
+	return &FrameReader{r: r, spec: spec, buf: make([]byte, size)}, nil
+}
+
+// Next returns the next frame. The frame is only valid until the next call.
+// It returns io.EOF at the end of the stream and io.ErrUnexpectedEOF if the
+// stream ends within a frame.
+func (f *FrameReader) Next() ([]byte, error) {
+	hl := f.spec.headerLen()
+	if _, err := io.ReadFull(f.r, f.buf[:hl]); err != nil {
+		return nil, err
+	}
+	n, err := f.spec.Len(f.buf[:hl])
+	if err != nil {
+		return nil, err
+	}
+	if n > len(f.buf) {
+		buf := make([]byte, n)
+		copy(buf, f.buf[:hl])
+		f.buf = buf
+	}
+	if _, err := io.ReadFull(f.r, f.buf[hl:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f.buf[:n], nil
+}
+
+// streamBuffer holds stream data that was written but not yet consumed.
+type streamBuffer struct {
+	buf []byte
+	off int // start of the unconsumed data
+}
+
+// write appends data, first reclaiming the consumed space when it is at
+// least half of the buffer.
+func (b *streamBuffer) write(data []byte) {
+	if b.off > 0 && b.off == len(b.buf) {
+		b.buf = b.buf[:0]
+		b.off = 0
+	} else if b.off > len(b.buf)/2 {
+		b.buf = b.buf[:copy(b.buf, b.buf[b.off:])]
+		b.off = 0
+	}
+	b.buf = append(b.buf, data...)
+}
+
+// bytes returns the unconsumed data, valid until the next write.
+func (b *streamBuffer) bytes() []byte { return b.buf[b.off:] }
+
+// consume marks the first n bytes of the unconsumed data as used.
+func (b *streamBuffer) consume(n int) { b.off += n }
+
+// FrameBuffer collects stream data as it arrives, for example from the
+// writers of a TcpStreams, and hands out complete frames.
+type FrameBuffer struct {
+	spec FrameSpec
+	buf  streamBuffer
+}
+
+// NewFrameBuffer creates an empty FrameBuffer.
+func NewFrameBuffer(spec FrameSpec) (*FrameBuffer, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return &FrameBuffer{spec: spec}, nil
+}
+
+// Write appends stream data to the buffer.
+func (f *FrameBuffer) Write(data []byte) (int, error) {
+	f.buf.write(data)
+	return len(data), nil
+}
+
+// Next returns the next complete frame, or nil if more data is needed.
+// The frame is only valid until the next call to Write.
+func (f *FrameBuffer) Next() ([]byte, error) {
+	data := f.buf.bytes()
+	n, err := f.spec.Len(data)
+	if err == ErrShortFrame || n > len(data) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.buf.consume(n)
+	return data[:n], nil
+}
+
+// Buffered returns the number of bytes not yet returned as frames.
+func (f *FrameBuffer) Buffered() int { return len(f.buf.bytes()) }
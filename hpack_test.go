@@ -0,0 +1,232 @@
+package pcap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type hpackStep struct {
+	block  string        // hex, spaces ignored
+	fields []HeaderField // decoded header list
+	table  []HeaderField // dynamic table afterwards, newest first
+	size   int           // dynamic table size afterwards
+}
+
+var (
+	hpackC3Requests = []hpackStep{
+		{
+			block: "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			fields: []HeaderField{
+				{":method", "GET"}, {":scheme", "http"}, {":path", "/"},
+				{":authority", "www.example.com"},
+			},
+			table: []HeaderField{{":authority", "www.example.com"}},
+			size:  57,
+		},
+		{
+			block: "8286 84be 5808 6e6f 2d63 6163 6865",
+			fields: []HeaderField{
+				{":method", "GET"}, {":scheme", "http"}, {":path", "/"},
+				{":authority", "www.example.com"}, {"cache-control", "no-cache"},
+			},
+			table: []HeaderField{
+				{"cache-control", "no-cache"}, {":authority", "www.example.com"},
+			},
+			size: 110,
+		},
+		{
+			block: "8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+			fields: []HeaderField{
+				{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"},
+				{":authority", "www.example.com"}, {"custom-key", "custom-value"},
+			},
+			table: []HeaderField{
+				{"custom-key", "custom-value"}, {"cache-control", "no-cache"},
+				{":authority", "www.example.com"},
+			},
+			size: 164,
+		},
+	}
+
+	hpackC5Responses = []hpackStep{
+		{
+			block: "4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133" +
+				"2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70" +
+				"6c65 2e63 6f6d",
+			fields: []HeaderField{
+				{":status", "302"}, {"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"},
+				{"location", "https://www.example.com"},
+			},
+			table: []HeaderField{
+				{"location", "https://www.example.com"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"},
+				{"cache-control", "private"}, {":status", "302"},
+			},
+			size: 222,
+		},
+		{
+			block: "4803 3330 37c1 c0bf",
+			fields: []HeaderField{
+				{":status", "307"}, {"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"},
+				{"location", "https://www.example.com"},
+			},
+			table: []HeaderField{
+				{":status", "307"}, {"location", "https://www.example.com"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"},
+				{"cache-control", "private"},
+			},
+			size: 222,
+		},
+		{
+			block: "88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d" +
+				"54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049" +
+				"5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e" +
+				"3d31",
+			fields: []HeaderField{
+				{":status", "200"}, {"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:22 GMT"},
+				{"location", "https://www.example.com"}, {"content-encoding", "gzip"},
+				{"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"},
+			},
+			table: []HeaderField{
+				{"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"},
+				{"content-encoding", "gzip"},
+				{"date", "Mon, 21 Oct 2013 20:13:22 GMT"},
+			},
+			size: 215,
+		},
+	}
+)
+
+// withBlocks returns steps with their blocks replaced, for the Huffman
+// encoded variants of the examples, which decode to the same state.
+func withBlocks(steps []hpackStep, blocks ...string) []hpackStep {
+	out := append([]hpackStep(nil), steps...)
+	for i := range out {
+		out[i].block = blocks[i]
+	}
+	return out
+}
+
+// TestHpackRFC7541 runs the examples of RFC 7541, Appendix C.
+func TestHpackRFC7541(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int
+		steps   []hpackStep
+	}{
+		{"C.2.1 literal with indexing", 4096, []hpackStep{{
+			block:  "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572",
+			fields: []HeaderField{{"custom-key", "custom-header"}},
+			table:  []HeaderField{{"custom-key", "custom-header"}},
+			size:   55,
+		}}},
+		{"C.2.2 literal without indexing", 4096, []hpackStep{{
+			block:  "040c 2f73 616d 706c 652f 7061 7468",
+			fields: []HeaderField{{":path", "/sample/path"}},
+		}}},
+		{"C.2.3 literal never indexed", 4096, []hpackStep{{
+			block:  "1008 7061 7373 776f 7264 0673 6563 7265 74",
+			fields: []HeaderField{{"password", "secret"}},
+		}}},
+		{"C.2.4 indexed", 4096, []hpackStep{{
+			block:  "82",
+			fields: []HeaderField{{":method", "GET"}},
+		}}},
+		{"C.3 requests", 4096, hpackC3Requests},
+		{"C.4 requests with huffman", 4096, withBlocks(hpackC3Requests,
+			"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+			"8286 84be 5886 a8eb 1064 9cbf",
+			"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+		)},
+		{"C.5 responses", 256, hpackC5Responses},
+		{"C.6 responses with huffman", 256, withBlocks(hpackC5Responses,
+			"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6"+
+				"2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+			"4883 640e ffc1 c0bf",
+			"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab"+
+				"77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f"+
+				"9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newHpackDecoder()
+			d.maxSize = tt.maxSize
+			for i, step := range tt.steps {
+				fields, err := d.decode(mustHex(t, strings.Replace(step.block, " ", "", -1)))
+				if err != nil {
+					t.Fatalf("block %d: %v", i, err)
+				}
+				if !reflect.DeepEqual(fields, step.fields) {
+					t.Errorf("block %d: fields %v, want %v", i, fields, step.fields)
+				}
+				if len(d.dynamic) != len(step.table) || len(step.table) > 0 && !reflect.DeepEqual(d.dynamic, step.table) {
+					t.Errorf("block %d: table %v, want %v", i, d.dynamic, step.table)
+				}
+				if d.size != step.size {
+					t.Errorf("block %d: table size %d, want %d", i, d.size, step.size)
+				}
+			}
+		})
+	}
+}
+
+func TestHpackMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+	}{
+		{"index zero", "80"},
+		{"index past dynamic table", "be"},
+		{"name index past dynamic table", "7f00 0161"},
+		{"unterminated integer", "ff80 80"},
+		{"integer overflow", "ff ffff ffff ffff ffff ffff ff7f"},
+		{"missing value", "4001 61"},
+		{"string past end", "0003 6162"},
+		{"huffman padding too long", "0001 61 82 ffff"},
+		{"huffman padding not ones", "0001 61 81 1e"},
+		{"huffman eos", "0001 61 84 ffff ffff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newHpackDecoder()
+			if fields, err := d.decode(mustHex(t, strings.Replace(tt.block, " ", "", -1))); err == nil {
+				t.Errorf("got %v, want an error", fields)
+			}
+		})
+	}
+}
+
+func TestHpackTableSizeUpdate(t *testing.T) {
+	d := newHpackDecoder()
+	for _, block := range []string{
+		"400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572",
+		"4003 666f 6f03 6261 72",
+	} {
+		if _, err := d.decode(mustHex(t, strings.Replace(block, " ", "", -1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		block string
+		size  int
+		table int
+	}{
+		{"3f19", 56, 1}, // 56 keeps the 38-byte foo: bar entry only
+		{"20", 0, 0},
+		{"3fe1 1f", 4096, 0},
+	}
+	for _, tt := range tests {
+		if _, err := d.decode(mustHex(t, strings.Replace(tt.block, " ", "", -1))); err != nil {
+			t.Fatal(err)
+		}
+		if d.maxSize != tt.size || len(d.dynamic) != tt.table {
+			t.Errorf("%s: max size %d with %d entries, want %d with %d",
+				tt.block, d.maxSize, len(d.dynamic), tt.size, tt.table)
+		}
+	}
+}
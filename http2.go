@@ -0,0 +1,440 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HTTP2_PREFACE is the connection preface sent first by HTTP/2 clients.
+const HTTP2_PREFACE = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// HTTP/2 frame types, RFC 7540.
+const (
+	HTTP2_DATA          = 0x0
+	HTTP2_HEADERS       = 0x1
+	HTTP2_PRIORITY      = 0x2
+	HTTP2_RST_STREAM    = 0x3
+	HTTP2_SETTINGS      = 0x4
+	HTTP2_PUSH_PROMISE  = 0x5
+	HTTP2_PING          = 0x6
+	HTTP2_GOAWAY        = 0x7
+	HTTP2_WINDOW_UPDATE = 0x8
+	HTTP2_CONTINUATION  = 0x9
+)
+
+// HTTP/2 frame flags.
+const (
+	HTTP2_FLAG_END_STREAM  = 0x01
+	HTTP2_FLAG_ACK         = 0x01
+	HTTP2_FLAG_END_HEADERS = 0x04
+	HTTP2_FLAG_PADDED      = 0x08
+	HTTP2_FLAG_PRIORITY    = 0x20
+)
+
+var errHttp2 = errors.New("pcap: malformed http2 frame")
+
+// Http2Frame is an HTTP/2 frame.
+type Http2Frame struct {
+	Length   int
+	Type     uint8 // see HTTP2_*
+	Flags    uint8
+	StreamId uint32
+	Payload  []byte
+}
+
+// DecodeHttp2Frame decodes the frame at the start of data and returns the
+// number of bytes it occupies, or ErrShortFrame if the frame is incomplete.
+func DecodeHttp2Frame(data []byte) (*Http2Frame, int, error) {
+	if len(data) < 9 {
+		return nil, 0, ErrShortFrame
+	}
+	f := &Http2Frame{
+		Length:   int(data[0])<<16 | int(data[1])<<8 | int(data[2]),
+		Type:     data[3],
+		Flags:    data[4],
+		StreamId: binary.BigEndian.Uint32(data[5:9]) & 0x7FFFFFFF,
+	}
+	if len(data) < 9+f.Length {
+		return nil, 0, ErrShortFrame
+	}
+	f.Payload = data[9 : 9+f.Length]
+	return f, 9 + f.Length, nil
+}
+
+// Http2Stream is what was learned about one stream of a connection. For
+// gRPC calls, messages are counted from the length-prefixed framing of the
+// DATA frames.
+type Http2Stream struct {
+	Id uint32
+
+	Method      string // request pseudo header fields
+	Scheme      string
+	Authority   string
+	Path        string
+	Status      string // response :status
+	ContentType string
+
+	RequestHeaders  []HeaderField
+	ResponseHeaders []HeaderField
+	Trailers        []HeaderField
+
+	RequestBytes  uint64 // DATA payload, without padding
+	ResponseBytes uint64
+	ClientClosed  bool // END_STREAM seen from the client
+	ServerClosed  bool
+	Reset         bool
+	ResetCode     uint32
+
+	// Grpc is set when the content type is application/grpc, or, when the
+	// headers could not be decoded, when the DATA frames look like gRPC
+	// messages.
+	Grpc             bool
+	GrpcService      string // package.Service, from the path
+	GrpcMethod       string
+	GrpcStatus       string // from the trailers
+	GrpcMessage      string
+	RequestMessages  int
+	ResponseMessages int
+
+	messages [2]grpcCounter
+}
+
+// grpcCounter follows the length-prefixed messages of one direction.
+type grpcCounter struct {
+	left   int    // bytes left in the current message
+	prefix []byte // partial message prefix
+}
+
+// count accounts DATA bytes and returns the number of messages started.
+func (g *grpcCounter) count(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		if g.left > 0 {
+			k := g.left
+			if k > len(b) {
+				k = len(b)
+			}
+			g.left -= k
+			b = b[k:]
+			continue
+		}
+		need := 5 - len(g.prefix)
+		if need > len(b) {
+			g.prefix = append(g.prefix, b...)
+			return n
+		}
+		g.prefix = append(g.prefix, b[:need]...)
+		b = b[need:]
+		g.left = int(binary.BigEndian.Uint32(g.prefix[1:5]))
+		g.prefix = g.prefix[:0]
+		n++
+	}
+	return n
+}
+
+// Label returns a short description of the stream, the RPC method for
+// gRPC calls.
+func (s *Http2Stream) Label() string {
+	if s.Grpc && s.GrpcMethod != "" {
+		return fmt.Sprintf("grpc %s/%s", s.GrpcService, s.GrpcMethod)
+	}
+	if s.Grpc {
+		return "grpc"
+	}
+	if s.Method != "" {
+		return fmt.Sprintf("%s %s%s", s.Method, s.Authority, s.Path)
+	}
+	return fmt.Sprintf("stream %d", s.Id)
+}
+
+func (s *Http2Stream) String() string {
+	status := s.Status
+	if s.Grpc && s.GrpcStatus != "" {
+		status = "grpc-status " + s.GrpcStatus
+	}
+	return fmt.Sprintf("HTTP2 STREAM=%d %s %s REQ=%d/%d RESP=%d/%d",
+		s.Id, s.Label(), status, s.RequestMessages, s.RequestBytes,
+		s.ResponseMessages, s.ResponseBytes)
+}
+
+func (s *Http2Stream) applyRequest(fields []HeaderField) {
+	s.RequestHeaders = fields
+	for _, f := range fields {
+		switch f.Name {
+		case ":method":
+			s.Method = f.Value
+		case ":scheme":
+			s.Scheme = f.Value
+		case ":authority", "host":
+			s.Authority = f.Value
+		case ":path":
+			s.Path = f.Value
+		case "content-type":
+			s.setContentType(f.Value)
+		}
+	}
+	if s.Grpc && strings.HasPrefix(s.Path, "/") {
+		if i := strings.LastIndexByte(s.Path, '/'); i > 0 {
+			s.GrpcService, s.GrpcMethod = s.Path[1:i], s.Path[i+1:]
+		}
+	}
+}
+
+func (s *Http2Stream) applyResponse(fields []HeaderField) {
+	if s.ResponseHeaders == nil {
+		s.ResponseHeaders = fields
+	} else {
+		s.Trailers = fields
+	}
+	for _, f := range fields {
+		switch f.Name {
+		case ":status":
+			s.Status = f.Value
+		case "content-type":
+			s.setContentType(f.Value)
+		case "grpc-status":
+			s.GrpcStatus = f.Value
+		case "grpc-message":
+			s.GrpcMessage = f.Value
+		}
+	}
+}
+
+func (s *Http2Stream) setContentType(v string) {
+	s.ContentType = v
+	if strings.HasPrefix(v, "application/grpc") {
+		s.Grpc = true
+	}
+}
+
+// Http2Conn follows both directions of an HTTP/2 connection, such as the
+// two directions reassembled by TcpStreams, and labels its streams. Each
+// direction must be fed from a frame boundary, ideally from the start of
+// the connection; header fields cannot be decoded when the capture missed
+// earlier header blocks, since HPACK state is shared across the connection.
+type Http2Conn struct {
+	Streams map[uint32]*Http2Stream
+	Events  *EventBus // receives EVENT_DECODE_ERROR when header decoding stops
+
+	half [2]http2Half // client, server
+}
+
+type http2Half struct {
+	buf     streamBuffer
+	preface bool // a client connection preface may follow
+	hpack   *hpackDecoder
+	broken  bool // header compression state was lost
+
+	block       []byte // header block awaiting CONTINUATION frames
+	blockStream uint32
+	blockEnd    bool // the HEADERS frame ended the stream
+	blockPush   bool // the block belongs to a PUSH_PROMISE
+}
+
+// NewHttp2Conn creates a tracker for one connection.
+func NewHttp2Conn() *Http2Conn {
+	c := &Http2Conn{Streams: make(map[uint32]*Http2Stream)}
+	c.half[0] = http2Half{preface: true, hpack: newHpackDecoder()}
+	c.half[1] = http2Half{hpack: newHpackDecoder()}
+	return c
+}
+
+// Client feeds bytes sent by the client.
+func (c *Http2Conn) Client(data []byte) error { return c.feed(0, data) }
+
+// Server feeds bytes sent by the server.
+func (c *Http2Conn) Server(data []byte) error { return c.feed(1, data) }
+
+// Stream returns a stream, or nil if it was never seen.
+func (c *Http2Conn) Stream(id uint32) *Http2Stream { return c.Streams[id] }
+
+// SortedStreams returns the streams ordered by id.
+func (c *Http2Conn) SortedStreams() []*Http2Stream {
+	streams := make([]*Http2Stream, 0, len(c.Streams))
+	for _, s := range c.Streams {
+		streams = append(streams, s)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].Id < streams[j].Id })
+	return streams
+}
+
+func (c *Http2Conn) stream(id uint32) *Http2Stream {
+	s := c.Streams[id]
+	if s == nil {
+		s = &Http2Stream{Id: id}
+		c.Streams[id] = s
+	}
+	return s
+}
+
+func (c *Http2Conn) feed(dir int, data []byte) error {
+	h := &c.half[dir]
+	h.buf.write(data)
+	if h.preface {
+		b := h.buf.bytes()
+		n := len(b)
+		if n > len(HTTP2_PREFACE) {
+			n = len(HTTP2_PREFACE)
+		}
+		if string(b[:n]) == HTTP2_PREFACE[:n] {
+			if n < len(HTTP2_PREFACE) {
+				return nil
+			}
+			h.buf.consume(n)
+		}
+		h.preface = false
+	}
+	for {
+		f, n, err := DecodeHttp2Frame(h.buf.bytes())
+		if err == ErrShortFrame {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		h.buf.consume(n)
+		if err := c.frame(dir, f); err != nil {
+			return err
+		}
+	}
+}
+
+// unpad strips the padding of DATA, HEADERS and PUSH_PROMISE frames.
+func unpad(f *Http2Frame) ([]byte, error) {
+	b := f.Payload
+	if f.Flags&HTTP2_FLAG_PADDED == 0 {
+		return b, nil
+	}
+	if len(b) < 1 || int(b[0]) >= len(b) {
+		return nil, errHttp2
+	}
+	return b[1 : len(b)-int(b[0])], nil
+}
+
+func (c *Http2Conn) frame(dir int, f *Http2Frame) error {
+	h := &c.half[dir]
+	if h.block != nil && f.Type != HTTP2_CONTINUATION {
+		return errHttp2
+	}
+	switch f.Type {
+	case HTTP2_DATA:
+		b, err := unpad(f)
+		if err != nil {
+			return err
+		}
+		s := c.stream(f.StreamId)
+		if !s.Grpc && s.ContentType == "" && s.messages[dir].left == 0 &&
+			len(b) >= 5 && b[0] <= 1 && int(binary.BigEndian.Uint32(b[1:5])) == len(b)-5 {
+			s.Grpc = true
+		}
+		n := 0
+		if s.Grpc {
+			n = s.messages[dir].count(b)
+		}
+		if dir == 0 {
+			s.RequestBytes += uint64(len(b))
+			s.RequestMessages += n
+		} else {
+			s.ResponseBytes += uint64(len(b))
+			s.ResponseMessages += n
+		}
+		c.endStream(dir, s, f.Flags)
+	case HTTP2_HEADERS:
+		b, err := unpad(f)
+		if err != nil {
+			return err
+		}
+		if f.Flags&HTTP2_FLAG_PRIORITY != 0 {
+			if len(b) < 5 {
+				return errHttp2
+			}
+			b = b[5:]
+		}
+		h.blockStream = f.StreamId
+		h.blockEnd = f.Flags&HTTP2_FLAG_END_STREAM != 0
+		h.blockPush = false
+		h.block = append([]byte{}, b...)
+		if f.Flags&HTTP2_FLAG_END_HEADERS != 0 {
+			c.headers(dir)
+		}
+	case HTTP2_PUSH_PROMISE:
+		b, err := unpad(f)
+		if err != nil {
+			return err
+		}
+		if len(b) < 4 {
+			return errHttp2
+		}
+		h.blockStream = binary.BigEndian.Uint32(b[0:4]) & 0x7FFFFFFF
+		h.blockEnd = false
+		h.blockPush = true
+		h.block = append([]byte{}, b[4:]...)
+		if f.Flags&HTTP2_FLAG_END_HEADERS != 0 {
+			c.headers(dir)
+		}
+	case HTTP2_CONTINUATION:
+		if h.block == nil || f.StreamId != h.blockStream && !h.blockPush {
+			return errHttp2
+		}
+		h.block = append(h.block, f.Payload...)
+		if f.Flags&HTTP2_FLAG_END_HEADERS != 0 {
+			c.headers(dir)
+		}
+	case HTTP2_RST_STREAM:
+		if len(f.Payload) < 4 {
+			return errHttp2
+		}
+		s := c.stream(f.StreamId)
+		s.Reset = true
+		s.ResetCode = binary.BigEndian.Uint32(f.Payload)
+	}
+	return nil
+}
+
+func (c *Http2Conn) endStream(dir int, s *Http2Stream, flags uint8) {
+	if flags&HTTP2_FLAG_END_STREAM == 0 {
+		return
+	}
+	if dir == 0 {
+		s.ClientClosed = true
+	} else {
+		s.ServerClosed = true
+	}
+}
+
+// headers decodes a complete header block.
+func (c *Http2Conn) headers(dir int) {
+	h := &c.half[dir]
+	block := h.block
+	h.block = nil
+	s := c.stream(h.blockStream)
+	if !h.blockPush && h.blockEnd {
+		c.endStream(dir, s, HTTP2_FLAG_END_STREAM)
+	}
+	if h.broken {
+		return
+	}
+	fields, err := h.hpack.decode(block)
+	if err != nil {
+		h.broken = true
+		c.Events.Publish(&Event{
+			Kind:    EVENT_DECODE_ERROR,
+			Source:  "http2",
+			Message: fmt.Sprintf("stream %d: header fields are no longer decoded", s.Id),
+			Err:     err,
+		})
+		return
+	}
+	if dir == 0 || h.blockPush {
+		if s.RequestHeaders == nil {
+			s.applyRequest(fields)
+		} else {
+			s.Trailers = fields
+		}
+		return
+	}
+	s.applyResponse(fields)
+}
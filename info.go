@@ -0,0 +1,93 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// CaptureInfo describes a capture file as found by NewReader.
+type CaptureInfo struct {
+	// Header is the file header as stored in the file. Reader.Header
+	// always reports NSEC_TCPDUMP_MAGIC, since Reader converts every
+	// timestamp to nanoseconds.
+	Header     FileHeader
+	ByteOrder  binary.ByteOrder // byte order of the file
+	Resolution time.Duration    // precision of the stored timestamps
+	Variant    string           // "pcap" or "pcap-nsec"
+
+	// Size and EstimatedPackets are only known when the reader supports
+	// seeking, and are -1 otherwise. The estimate extrapolates from the
+	// average size of the first packets.
+	Size             int64
+	EstimatedPackets int64
+}
+
+// estimateSample is the number of records used to estimate the packet count.
+const estimateSample = 16
+
+func newCaptureInfo(magic uint32, flip bool, header FileHeader) CaptureInfo {
+	info := CaptureInfo{
+		Header:           header,
+		ByteOrder:        binary.LittleEndian,
+		Resolution:       time.Microsecond,
+		Variant:          "pcap",
+		Size:             -1,
+		EstimatedPackets: -1,
+	}
+	info.Header.MagicNumber = magic
+	if flip {
+		info.ByteOrder = binary.BigEndian
+	}
+	if magic == NSEC_TCPDUMP_MAGIC {
+		info.Resolution = time.Nanosecond
+		info.Variant = "pcap-nsec"
+	}
+	return info
+}
+
+// estimate fills in the file size and packet count estimate, and returns to
+// the first record.
+func (r *Reader) estimate() {
+	start, err := r.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	end, err := r.seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	if _, err := r.seeker.Seek(start, io.SeekStart); err != nil {
+		r.err = err
+		return
+	}
+	r.Info.Size = end
+	flip, header, info, segments := r.flip, r.Header, r.Info, len(r.Segments)
+	var n, bytes int64
+	for ; n < estimateSample; n++ {
+		hdr, ok := r.NextHeader()
+		if !ok {
+			break
+		}
+		bytes += 16 + int64(hdr.Caplen)
+	}
+	r.err = nil
+	r.packets = 0
+	r.flip, r.Header, r.Info, r.Segments = flip, header, info, r.Segments[:segments]
+	if _, err := r.seeker.Seek(start, io.SeekStart); err != nil {
+		r.err = err
+		return
+	}
+	switch {
+	case n == 0:
+		r.Info.EstimatedPackets = 0
+	case n < estimateSample:
+		r.Info.EstimatedPackets = n
+	default:
+		r.Info.EstimatedPackets = (end - start) * n / bytes
+	}
+}
+
+func swapUint32(v uint32) uint32 {
+	return v>>24 | v>>8&0xff00 | v<<8&0xff0000 | v<<24
+}
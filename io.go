@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"sync"
 	"time"
 )
@@ -28,13 +29,30 @@ type FileHeader struct {
 type Reader struct {
 	flip         bool
 	buf          io.Reader
+	seeker       io.Seeker // buf, if it supports seeking
 	err          error
 	fourBytes    []byte
 	twoBytes     []byte
 	sixteenBytes []byte
 	DataPool     *sync.Pool
 	Header       FileHeader
+	Info         CaptureInfo
 	Count        int
+	Events       *EventBus // receives EVENT_DECODE_ERROR and EVENT_SEGMENT
+
+	// Segments lists the file headers found so far, the first one
+	// included. Streams of concatenated files are read through, switching
+	// Header and Info to the file at hand.
+	Segments []Segment
+	packets  int // records read
+}
+
+// PacketHeader is the record header of a packet, without its data.
+type PacketHeader struct {
+	Time      time.Time // packet send/receive time
+	Timestamp Timestamp // Time as stored in the file
+	Caplen    uint32    // bytes stored in the file (caplen <= len)
+	Len       uint32    // bytes sent/received
 }
 
 type PacketData struct {
@@ -54,11 +72,18 @@ func NewReader(reader io.Reader) (r *Reader, err error) {
 		twoBytes:     make([]byte, 2),
 		sixteenBytes: make([]byte, 16),
 	}
-	switch magic := r.readUint32(); magic {
+	if s, ok := reader.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekCurrent); err == nil {
+			r.seeker = s
+		}
+	}
+	magic := r.readUint32()
+	switch magic {
 	case 0xa1b2c3d4, 0xa1b23c4d:
 		r.flip = false
 	case 0xd4c3b2a1, 0x4d3cb2a1:
 		r.flip = true
+		magic = swapUint32(magic)
 	default:
 		return nil, fmt.Errorf("pcap: bad magic number: %0x", magic)
 	}
@@ -77,40 +102,167 @@ func NewReader(reader io.Reader) (r *Reader, err error) {
 			// types, since a pointer can be put into the return interface
 			// value without an allocation:
 			r.Count++
-			return NewPacketData(int(r.Header.SnapLen))
+			return NewPacketData(int(r.snapLen()))
 		},
 	}
+	r.Info = newCaptureInfo(magic, r.flip, r.Header)
+	r.Segments = []Segment{{Header: r.Info.Header, Info: r.Info, FirstPacket: 1}}
+	if r.err == nil && r.seeker != nil {
+		r.estimate()
+	}
 	return r, err
 }
 
 // Next returns the next packet or nil if no more packets can be read.
 func (r *Reader) Next() *Packet {
-	d := r.sixteenBytes
-	r.err = r.read(d)
-	if r.err != nil {
+	hdr, ok := r.readHeader()
+	if !ok {
 		return nil
 	}
-	timeSec := asUint32(d[0:4], r.flip)
-	timeUsec := asUint32(d[4:8], r.flip)
-	capLen := asUint32(d[8:12], r.flip)
-	origLen := asUint32(d[12:16], r.flip)
 
 	packetData := r.DataPool.Get().(*PacketData)
 	//fmt.Printf("malloc %p\n", packetData)
-	//packetData.Data = packetData.Data[:capLen]
-	if r.err = r.read(packetData.Data); r.err != nil {
+	if int(hdr.Caplen) > cap(packetData.Data) {
+		packetData.Data = make([]byte, hdr.Caplen)
+	}
+	data := packetData.Data[:hdr.Caplen]
+	if r.err = r.read(data); r.err != nil {
+		r.DataPool.Put(packetData)
+		r.truncated(hdr)
 		return nil
 	}
 	return &Packet{
-		Time:       time.Unix(int64(timeSec), int64(timeUsec)),
-		Caplen:     capLen,
-		Len:        origLen,
-		Data:       packetData.Data,
+		Time:       hdr.Time,
+		Timestamp:  hdr.Timestamp,
+		Caplen:     hdr.Caplen,
+		Len:        hdr.Len,
+		Data:       data,
 		PacketData: packetData,
 		Pool:       r.DataPool,
 	}
 }
 
+// NextHeader returns the record header of the next packet and skips its
+// data, without taking a buffer from the DataPool. It returns false if no
+// more packets can be read.
+func (r *Reader) NextHeader() (PacketHeader, bool) {
+	hdr, ok := r.readHeader()
+	if !ok {
+		return hdr, false
+	}
+	if r.err = r.skip(hdr.Caplen); r.err != nil {
+		r.truncated(hdr)
+		return hdr, false
+	}
+	return hdr, true
+}
+
+// Skip skips the next n packets and returns the number skipped. Packet data
+// is seeked over when the underlying reader supports it, or discarded
+// otherwise. The error is io.EOF if the file ends before n packets.
+func (r *Reader) Skip(n int) (int, error) {
+	for i := 0; i < n; i++ {
+		if _, ok := r.NextHeader(); !ok {
+			return i, r.err
+		}
+	}
+	return n, nil
+}
+
+func (r *Reader) readHeader() (hdr PacketHeader, ok bool) {
+	d := r.sixteenBytes
+	for {
+		if r.err = r.read(d); r.err != nil {
+			return hdr, false
+		}
+		if !r.segment(d) {
+			break
+		}
+	}
+	if r.err != nil {
+		return hdr, false
+	}
+	timeSec := asUint32(d[0:4], r.flip)
+	timeFrac := asUint32(d[4:8], r.flip)
+	hdr.Timestamp = Timestamp{Sec: timeSec, Frac: timeFrac, Resolution: r.Info.Resolution}
+	hdr.Time = hdr.Timestamp.Time()
+	hdr.Caplen = asUint32(d[8:12], r.flip)
+	hdr.Len = asUint32(d[12:16], r.flip)
+	r.packets++
+	if hdr.Caplen > MAX_CAPLEN {
+		r.err = fmt.Errorf("pcap: packet %d: caplen %d exceeds limit %d", r.packets, hdr.Caplen, MAX_CAPLEN)
+		r.Events.Publish(&Event{
+			Kind:   EVENT_DECODE_ERROR,
+			Source: "reader",
+			Time:   hdr.Time,
+			Packet: r.packets,
+			Count:  int64(hdr.Caplen),
+			Err:    r.err,
+		})
+		return hdr, false
+	}
+	if hdr.Caplen > hdr.Len || hdr.Caplen > r.Header.SnapLen {
+		r.Events.Publish(&Event{
+			Kind:    EVENT_DECODE_ERROR,
+			Source:  "reader",
+			Time:    hdr.Time,
+			Packet:  r.packets,
+			Count:   int64(hdr.Caplen),
+			Message: fmt.Sprintf("caplen %d exceeds len %d or snaplen %d", hdr.Caplen, hdr.Len, r.Header.SnapLen),
+		})
+	}
+	return hdr, true
+}
+
+// MAX_CAPLEN is the longest record accepted regardless of the snapshot
+// length in the file header, as in libpcap.
+const MAX_CAPLEN = 262144
+
+// snapLen returns the snapshot length used to size pooled buffers. Like
+// libpcap, a zero snapshot length or one above MAX_CAPLEN is taken to be
+// corrupt and MAX_CAPLEN is used instead, so that a bad header cannot make
+// buffers arbitrarily large.
+func (r *Reader) snapLen() uint32 {
+	if r.Header.SnapLen == 0 || r.Header.SnapLen > MAX_CAPLEN {
+		return MAX_CAPLEN
+	}
+	return r.Header.SnapLen
+}
+
+// truncated reports a record whose data could not be read.
+func (r *Reader) truncated(hdr PacketHeader) {
+	r.Events.Publish(&Event{
+		Kind:    EVENT_DECODE_ERROR,
+		Source:  "reader",
+		Time:    hdr.Time,
+		Packet:  r.packets,
+		Count:   int64(hdr.Caplen),
+		Message: "truncated packet record",
+		Err:     r.err,
+	})
+}
+
+func (r *Reader) skip(n uint32) error {
+	if r.seeker != nil && n > 0 {
+		// Seeking past the end succeeds, so read the last byte to detect
+		// a truncated record as io.CopyN does.
+		if _, err := r.seeker.Seek(int64(n)-1, io.SeekCurrent); err != nil {
+			return err
+		}
+		return r.read(r.fourBytes[:1])
+	}
+	_, err := io.CopyN(ioutil.Discard, r.buf, int64(n))
+	return err
+}
+
+// Err returns the error that stopped Next, or nil at the end of the file.
+func (r *Reader) Err() error {
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
+
 func (r *Reader) read(data []byte) error {
 	var err error
 	n, err := r.buf.Read(data)
@@ -151,41 +303,120 @@ func (r *Reader) readUint16() uint16 {
 
 // Writer writes a pcap file.
 type Writer struct {
-	writer io.Writer
-	buf    []byte
+	// TimeFunc, if set, returns the timestamp recorded for a packet instead
+	// of its capture time, see TimestampField.
+	TimeFunc func(*Packet) time.Time
+
+	// Rounded counts the packets whose timestamp was rounded down to the
+	// resolution of the file, such as nanosecond timestamps written to a
+	// microsecond file.
+	Rounded int
+
+	writer     io.Writer
+	buf        []byte
+	order      binary.ByteOrder
+	resolution time.Duration
+	stream     bool      // flush after every packet, see NewStreamWriter
+	offset     int64     // bytes written
+	last       Timestamp // timestamp of the last packet written
 }
 
 // NewWriter creates a Writer that stores output in an io.Writer.
-// The FileHeader is written immediately.
+// The FileHeader is written immediately, in little endian byte order.
+// Timestamps are written in microseconds if the header has TCPDUMP_MAGIC,
+// and in nanoseconds otherwise.
 func NewWriter(writer io.Writer, header *FileHeader) (*Writer, error) {
+	resolution := time.Nanosecond
+	if header.MagicNumber == TCPDUMP_MAGIC {
+		resolution = time.Microsecond
+	}
+	return newWriter(writer, header, binary.LittleEndian, resolution)
+}
+
+// NewPreservingWriter creates a Writer that reproduces the magic number,
+// byte order and timestamp precision of the file described by info, so
+// that packets copied from its Reader are written byte for byte as they
+// were read. For an info not filled in by a Reader, a nil ByteOrder means
+// little endian and a zero Resolution is taken from the magic number, as
+// in NewWriter.
+func NewPreservingWriter(writer io.Writer, info *CaptureInfo) (*Writer, error) {
+	order := info.ByteOrder
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	resolution := info.Resolution
+	if resolution == 0 {
+		resolution = time.Nanosecond
+		if info.Header.MagicNumber == TCPDUMP_MAGIC {
+			resolution = time.Microsecond
+		}
+	}
+	if resolution != time.Microsecond && resolution != time.Nanosecond {
+		return nil, fmt.Errorf("pcap: bad timestamp resolution: %v", resolution)
+	}
+	return newWriter(writer, &info.Header, order, resolution)
+}
+
+func newWriter(writer io.Writer, header *FileHeader, order binary.ByteOrder, resolution time.Duration) (*Writer, error) {
 	w := &Writer{
-		writer: writer,
-		buf:    make([]byte, 24),
-	}
-	binary.LittleEndian.PutUint32(w.buf, header.MagicNumber)
-	binary.LittleEndian.PutUint16(w.buf[4:], header.VersionMajor)
-	binary.LittleEndian.PutUint16(w.buf[6:], header.VersionMinor)
-	binary.LittleEndian.PutUint32(w.buf[8:], uint32(header.TimeZone))
-	binary.LittleEndian.PutUint32(w.buf[12:], header.SigFigs)
-	binary.LittleEndian.PutUint32(w.buf[16:], header.SnapLen)
-	binary.LittleEndian.PutUint32(w.buf[20:], header.LinkType)
+		writer:     writer,
+		buf:        make([]byte, 24),
+		order:      order,
+		resolution: resolution,
+	}
+	order.PutUint32(w.buf, header.MagicNumber)
+	order.PutUint16(w.buf[4:], header.VersionMajor)
+	order.PutUint16(w.buf[6:], header.VersionMinor)
+	order.PutUint32(w.buf[8:], uint32(header.TimeZone))
+	order.PutUint32(w.buf[12:], header.SigFigs)
+	order.PutUint32(w.buf[16:], header.SnapLen)
+	order.PutUint32(w.buf[20:], header.LinkType)
 	if _, err := writer.Write(w.buf); err != nil {
 		return nil, err
 	}
+	w.offset = int64(len(w.buf))
 	return w, nil
 }
 
-// Writer writes a packet to the underlying writer.
+// Offset returns the number of bytes written so far, which is the file
+// offset of the next packet record.
+func (w *Writer) Offset() int64 { return w.offset }
+
+// LastTimestamp returns the timestamp stored in the record of the last
+// packet written. Its Source is the packet's, when the packet's Timestamp
+// was written; the file itself does not store it.
+func (w *Writer) LastTimestamp() Timestamp { return w.last }
+
+// Writer writes a packet to the underlying writer. The raw fields of the
+// packet's Timestamp are converted to the resolution of the file, unless
+// TimeFunc is set or Time was changed after the packet was read.
 func (w *Writer) Write(pkt *Packet) error {
-	binary.LittleEndian.PutUint32(w.buf, uint32(pkt.Time.Unix()))
-	binary.LittleEndian.PutUint32(w.buf[4:], uint32(pkt.Time.Nanosecond()))
-	binary.LittleEndian.PutUint32(w.buf[8:], pkt.Caplen)
-	binary.LittleEndian.PutUint32(w.buf[12:], pkt.Len)
+	var ts Timestamp
+	var exact bool
+	switch {
+	case w.TimeFunc != nil:
+		ts, exact = NewTimestamp(w.TimeFunc(pkt), w.resolution)
+	case pkt.Timestamp.IsZero() || !pkt.Timestamp.Time().Equal(pkt.Time):
+		ts, exact = NewTimestamp(pkt.Time, w.resolution)
+	default:
+		ts, exact = pkt.Timestamp.Convert(w.resolution)
+	}
+	if !exact {
+		w.Rounded++
+	}
+	w.order.PutUint32(w.buf, ts.Sec)
+	w.order.PutUint32(w.buf[4:], ts.Frac)
+	w.order.PutUint32(w.buf[8:], pkt.Caplen)
+	w.order.PutUint32(w.buf[12:], pkt.Len)
 	if _, err := w.writer.Write(w.buf[:16]); err != nil {
-		return err
+		return w.writeErr(err)
 	}
-	_, err := w.writer.Write(pkt.Data)
-	return err
+	if _, err := w.writer.Write(pkt.Data); err != nil {
+		return w.writeErr(err)
+	}
+	w.offset += 16 + int64(len(pkt.Data))
+	w.last = ts
+	return w.flush()
 }
 
 func asUint32(data []byte, flip bool) uint32 {
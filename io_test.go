@@ -0,0 +1,107 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// rawPcap returns a little endian microsecond file with the given snapshot
+// length and one record of caplen bytes, of which data holds only len(data).
+func rawPcap(snapLen, caplen uint32, data []byte) []byte {
+	b := make([]byte, 24+16)
+	binary.LittleEndian.PutUint32(b[0:], TCPDUMP_MAGIC)
+	binary.LittleEndian.PutUint16(b[4:], 2)
+	binary.LittleEndian.PutUint16(b[6:], 4)
+	binary.LittleEndian.PutUint32(b[16:], snapLen)
+	binary.LittleEndian.PutUint32(b[20:], 1)
+	binary.LittleEndian.PutUint32(b[32:], caplen)
+	binary.LittleEndian.PutUint32(b[36:], caplen)
+	return append(b, data...)
+}
+
+func TestReaderSnapLen(t *testing.T) {
+	tests := []struct {
+		name    string
+		snapLen uint32
+		caplen  uint32
+		ok      bool
+		buffer  int // capacity of the pooled buffer
+	}{
+		{"small snaplen", 128, 4, true, 128},
+		{"zero snaplen", 0, 4, true, MAX_CAPLEN},
+		{"corrupt snaplen", 0x7fffffff, 4, true, MAX_CAPLEN},
+		{"caplen above snaplen", 64, 100, true, 100},
+		{"caplen at limit", 0x7fffffff, MAX_CAPLEN, true, MAX_CAPLEN},
+		{"caplen above limit", 0x7fffffff, MAX_CAPLEN + 1, false, 0},
+		{"caplen above limit and snaplen", 128, 0xf0000000, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.caplen)
+			if !tt.ok {
+				data = data[:4]
+			}
+			r, err := NewReader(bytes.NewReader(rawPcap(tt.snapLen, tt.caplen, data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := r.Next()
+			if !tt.ok {
+				if p != nil || r.Err() == nil {
+					t.Fatalf("got packet %v and error %v, want an error", p, r.Err())
+				}
+				return
+			}
+			if p == nil {
+				t.Fatal(r.Err())
+			}
+			if len(p.Data) != int(tt.caplen) || cap(p.PacketData.Data) != tt.buffer {
+				t.Errorf("data %d bytes in a %d byte buffer, want %d in %d",
+					len(p.Data), cap(p.PacketData.Data), tt.caplen, tt.buffer)
+			}
+		})
+	}
+}
+
+func TestNewPreservingWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		info       CaptureInfo
+		order      binary.ByteOrder
+		resolution time.Duration
+		ok         bool
+	}{
+		{"from reader", CaptureInfo{Header: FileHeader{MagicNumber: TCPDUMP_MAGIC}, ByteOrder: binary.BigEndian, Resolution: time.Microsecond},
+			binary.BigEndian, time.Microsecond, true},
+		{"defaults for microseconds", CaptureInfo{Header: FileHeader{MagicNumber: TCPDUMP_MAGIC}},
+			binary.LittleEndian, time.Microsecond, true},
+		{"defaults for nanoseconds", CaptureInfo{Header: FileHeader{MagicNumber: NSEC_TCPDUMP_MAGIC}},
+			binary.LittleEndian, time.Nanosecond, true},
+		{"bad resolution", CaptureInfo{Header: FileHeader{MagicNumber: TCPDUMP_MAGIC}, Resolution: time.Millisecond},
+			nil, 0, false},
+		{"negative resolution", CaptureInfo{Header: FileHeader{MagicNumber: TCPDUMP_MAGIC}, Resolution: -1},
+			nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := NewPreservingWriter(&bytes.Buffer{}, &tt.info)
+			if !tt.ok {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.order != tt.order || w.resolution != tt.resolution {
+				t.Errorf("order %v resolution %v, want %v %v", w.order, w.resolution, tt.order, tt.resolution)
+			}
+			if err := w.Write(&Packet{Time: time.Unix(1, 1500), Caplen: 1, Len: 1, Data: []byte{0}}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
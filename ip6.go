@@ -0,0 +1,100 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// IPv6 extension headers and other next header values, RFC 8200.
+const (
+	IP6_HOPOPTS  = 0
+	IP6_ROUTING  = 43
+	IP6_FRAGMENT = 44
+	IP6_ESP      = 50
+	IP6_AH       = 51
+	IP6_ICMP     = 58
+	IP6_NONE     = 59
+	IP6_DSTOPTS  = 60
+)
+
+// Ip6hdr is the header of an IPv6 packet.
+type Ip6hdr struct {
+	Version      uint8
+	TrafficClass uint8
+	FlowLabel    uint32
+	Length       uint16 // payload length, including extension headers
+	NextHeader   uint8
+	HopLimit     uint8
+	SrcIp        []byte
+	DestIp       []byte
+
+	// Protocol is the header following the extension headers, see IP_*.
+	// It is IP6_FRAGMENT for fragments other than the first.
+	Protocol uint8
+
+	raw []byte // fixed header bytes within Packet.Data
+}
+
+func (ip *Ip6hdr) SrcAddr() string  { return net.IP(ip.SrcIp).String() }
+func (ip *Ip6hdr) DestAddr() string { return net.IP(ip.DestIp).String() }
+func (ip *Ip6hdr) Len() int         { return int(ip.Length) + 40 }
+
+func (p *Packet) decodeIp6() {
+	if len(p.Payload) < 40 {
+		return
+	}
+	pkt := p.Payload
+
+	p.Ip6hdr.Version = pkt[0] >> 4
+	p.Ip6hdr.TrafficClass = uint8(binary.BigEndian.Uint16(pkt[0:2]) >> 4)
+	p.Ip6hdr.FlowLabel = binary.BigEndian.Uint32(pkt[0:4]) & 0x000FFFFF
+	p.Ip6hdr.Length = binary.BigEndian.Uint16(pkt[4:6])
+	p.Ip6hdr.NextHeader = pkt[6]
+	p.Ip6hdr.HopLimit = pkt[7]
+	p.Ip6hdr.SrcIp = pkt[8:24]
+	p.Ip6hdr.DestIp = pkt[24:40]
+	p.Ip6hdr.raw = pkt[:40]
+	pEnd := 40 + int(p.Ip6hdr.Length)
+	if pEnd > len(pkt) {
+		pEnd = len(pkt)
+	}
+	pkt = pkt[40:pEnd]
+
+	next := p.Ip6hdr.NextHeader
+	for {
+		var n int
+		switch next {
+		case IP6_HOPOPTS, IP6_ROUTING, IP6_DSTOPTS:
+			if len(pkt) < 2 {
+				return
+			}
+			n = (int(pkt[1]) + 1) * 8
+		case IP6_AH:
+			if len(pkt) < 2 {
+				return
+			}
+			n = (int(pkt[1]) + 2) * 4
+		case IP6_FRAGMENT:
+			if len(pkt) < 8 {
+				return
+			}
+			if binary.BigEndian.Uint16(pkt[2:4])&0xFFF8 != 0 {
+				// Not the first fragment, the upper layer header is elsewhere.
+				p.Ip6hdr.Protocol = IP6_FRAGMENT
+				p.Payload = pkt[8:]
+				return
+			}
+			n = 8
+		default:
+			p.Ip6hdr.Protocol = next
+			p.Payload = pkt
+			p.decodeIpProto(next)
+			return
+		}
+		if n > len(pkt) {
+			return
+		}
+		next = pkt[0]
+		pkt = pkt[n:]
+	}
+}
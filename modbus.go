@@ -0,0 +1,97 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TCP port of Modbus/TCP servers.
+const MODBUS_PORT = 502
+
+// Modbus function codes.
+const (
+	MODBUS_READ_COILS                    = 1
+	MODBUS_READ_DISCRETE_INPUTS          = 2
+	MODBUS_READ_HOLDING_REGISTERS        = 3
+	MODBUS_READ_INPUT_REGISTERS          = 4
+	MODBUS_WRITE_SINGLE_COIL             = 5
+	MODBUS_WRITE_SINGLE_REGISTER         = 6
+	MODBUS_WRITE_MULTIPLE_COILS          = 15
+	MODBUS_WRITE_MULTIPLE_REGISTERS      = 16
+	MODBUS_READ_WRITE_MULTIPLE_REGISTERS = 23
+)
+
+// ModbusFrameSpec frames Modbus/TCP ADUs in a stream reassembled by
+// TcpStreams, for use with FrameReader or FrameBuffer: the MBAP length
+// field counts the bytes that follow it.
+var ModbusFrameSpec = FrameSpec{Offset: 4, Size: 2, MaxFrame: 260}
+
+var errModbus = errors.New("pcap: malformed modbus ADU")
+
+// ModbusAdu is a Modbus/TCP application data unit.
+type ModbusAdu struct {
+	TransactionId uint16
+	ProtocolId    uint16 // 0 for Modbus
+	Length        uint16 // bytes following the length field
+	UnitId        uint8
+	Function      uint8 // see MODBUS_*, without the exception bit
+	Exception     uint8 // exception code, 0 if the ADU is not an exception
+	Data          []byte
+}
+
+// RequestRange returns the first address and quantity of a read or
+// multiple write request. It must only be used on requests, since
+// responses to the same functions carry a byte count instead.
+func (a *ModbusAdu) RequestRange() (addr, quantity uint16, ok bool) {
+	switch a.Function {
+	case MODBUS_READ_COILS, MODBUS_READ_DISCRETE_INPUTS,
+		MODBUS_READ_HOLDING_REGISTERS, MODBUS_READ_INPUT_REGISTERS,
+		MODBUS_WRITE_MULTIPLE_COILS, MODBUS_WRITE_MULTIPLE_REGISTERS,
+		MODBUS_READ_WRITE_MULTIPLE_REGISTERS:
+	default:
+		return 0, 0, false
+	}
+	if a.Exception != 0 || len(a.Data) < 4 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint16(a.Data[0:2]), binary.BigEndian.Uint16(a.Data[2:4]), true
+}
+
+func (a *ModbusAdu) String() string {
+	if a.Exception != 0 {
+		return fmt.Sprintf("MODBUS TID=%d UNIT=%d FC=%d EXCEPTION=%d",
+			a.TransactionId, a.UnitId, a.Function, a.Exception)
+	}
+	return fmt.Sprintf("MODBUS TID=%d UNIT=%d FC=%d LEN=%d",
+		a.TransactionId, a.UnitId, a.Function, len(a.Data))
+}
+
+// DecodeModbus decodes one Modbus/TCP ADU, as returned by a FrameReader
+// using ModbusFrameSpec.
+func DecodeModbus(frame []byte) (*ModbusAdu, error) {
+	if len(frame) < 8 {
+		return nil, ErrShortFrame
+	}
+	a := &ModbusAdu{
+		TransactionId: binary.BigEndian.Uint16(frame[0:2]),
+		ProtocolId:    binary.BigEndian.Uint16(frame[2:4]),
+		Length:        binary.BigEndian.Uint16(frame[4:6]),
+		UnitId:        frame[6],
+		Function:      frame[7] & 0x7F,
+	}
+	if a.Length < 2 {
+		return nil, errModbus
+	}
+	if len(frame) < 6+int(a.Length) {
+		return nil, ErrShortFrame
+	}
+	a.Data = frame[8 : 6+int(a.Length)]
+	if frame[7]&0x80 != 0 {
+		if len(a.Data) < 1 {
+			return nil, errModbus
+		}
+		a.Exception = a.Data[0]
+	}
+	return a, nil
+}
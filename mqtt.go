@@ -0,0 +1,306 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TCP ports of MQTT brokers.
+const (
+	MQTT_PORT     = 1883
+	MQTT_TLS_PORT = 8883
+)
+
+// MQTT control packet types.
+const (
+	MQTT_CONNECT     = 1
+	MQTT_CONNACK     = 2
+	MQTT_PUBLISH     = 3
+	MQTT_PUBACK      = 4
+	MQTT_PUBREC      = 5
+	MQTT_PUBREL      = 6
+	MQTT_PUBCOMP     = 7
+	MQTT_SUBSCRIBE   = 8
+	MQTT_SUBACK      = 9
+	MQTT_UNSUBSCRIBE = 10
+	MQTT_UNSUBACK    = 11
+	MQTT_PINGREQ     = 12
+	MQTT_PINGRESP    = 13
+	MQTT_DISCONNECT  = 14
+	MQTT_AUTH        = 15
+)
+
+var mqttTypes = [...]string{"", "CONNECT", "CONNACK", "PUBLISH", "PUBACK",
+	"PUBREC", "PUBREL", "PUBCOMP", "SUBSCRIBE", "SUBACK", "UNSUBSCRIBE",
+	"UNSUBACK", "PINGREQ", "PINGRESP", "DISCONNECT", "AUTH"}
+
+var errMqtt = errors.New("pcap: malformed mqtt packet")
+
+// MqttPacket is an MQTT control packet. Variable headers are decoded as
+// defined by MQTT 3.1.1; of MQTT 5 only the CONNECT packet is understood.
+type MqttPacket struct {
+	Type            uint8 // see MQTT_*
+	Flags           uint8
+	RemainingLength int
+	PacketId        uint16
+
+	// CONNECT
+	ProtocolName  string
+	ProtocolLevel uint8 // 4 for MQTT 3.1.1, 5 for MQTT 5
+	ConnectFlags  uint8
+	KeepAlive     uint16
+	ClientId      string
+
+	// CONNACK
+	SessionPresent bool
+	ReturnCode     uint8
+
+	// PUBLISH
+	Topic   string
+	Payload []byte
+
+	// SUBSCRIBE and UNSUBSCRIBE topic filters, with the requested QoS of
+	// SUBSCRIBE; SUBACK return codes.
+	Topics []string
+	Qoss   []uint8
+}
+
+// Dup, Qos and Retain return the flags of PUBLISH packets.
+func (m *MqttPacket) Dup() bool    { return m.Flags&0x08 != 0 }
+func (m *MqttPacket) Qos() uint8   { return m.Flags >> 1 & 0x03 }
+func (m *MqttPacket) Retain() bool { return m.Flags&0x01 != 0 }
+
+func (m *MqttPacket) String() string {
+	name := fmt.Sprintf("type=%d", m.Type)
+	if int(m.Type) < len(mqttTypes) && m.Type != 0 {
+		name = mqttTypes[m.Type]
+	}
+	switch m.Type {
+	case MQTT_CONNECT:
+		return fmt.Sprintf("MQTT %s %s/%d CLIENT=%q KEEPALIVE=%d", name, m.ProtocolName, m.ProtocolLevel, m.ClientId, m.KeepAlive)
+	case MQTT_CONNACK:
+		return fmt.Sprintf("MQTT %s RC=%d", name, m.ReturnCode)
+	case MQTT_PUBLISH:
+		return fmt.Sprintf("MQTT %s TOPIC=%q QOS=%d ID=%d LEN=%d", name, m.Topic, m.Qos(), m.PacketId, len(m.Payload))
+	case MQTT_SUBSCRIBE, MQTT_UNSUBSCRIBE:
+		return fmt.Sprintf("MQTT %s ID=%d TOPICS=%q", name, m.PacketId, m.Topics)
+	}
+	return fmt.Sprintf("MQTT %s ID=%d", name, m.PacketId)
+}
+
+// MqttLen returns the length of the control packet at the start of data.
+// It returns ErrShortFrame when data does not hold the complete fixed
+// header.
+func MqttLen(data []byte) (int, error) {
+	n, hl, err := mqttRemaining(data)
+	if err != nil {
+		return 0, err
+	}
+	return hl + n, nil
+}
+
+// mqttRemaining decodes the remaining length and returns it with the
+// length of the fixed header.
+func mqttRemaining(data []byte) (n, hl int, err error) {
+	if len(data) < 2 {
+		return 0, 0, ErrShortFrame
+	}
+	n, size, err := mqttVarint(data[1:])
+	return n, 1 + size, err
+}
+
+// mqttVarint decodes a variable byte integer and returns it with its size.
+func mqttVarint(b []byte) (v, size int, err error) {
+	shift := uint(0)
+	for i := 0; i < 4; i++ {
+		if i >= len(b) {
+			return 0, 0, ErrShortFrame
+		}
+		v |= int(b[i]&0x7F) << shift
+		if b[i]&0x80 == 0 {
+			return v, i + 1, nil
+		}
+		shift += 7
+	}
+	return 0, 0, errMqtt
+}
+
+// DecodeMqtt decodes the control packet at the start of data and returns
+// the number of bytes it occupies. It returns ErrShortFrame if data holds
+// an incomplete packet, so that stream data can be decoded as it arrives.
+func DecodeMqtt(data []byte) (*MqttPacket, int, error) {
+	n, hl, err := mqttRemaining(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < hl+n {
+		return nil, 0, ErrShortFrame
+	}
+	m := &MqttPacket{
+		Type:            data[0] >> 4,
+		Flags:           data[0] & 0x0F,
+		RemainingLength: n,
+	}
+	if err := m.decode(data[hl : hl+n]); err != nil {
+		return nil, 0, err
+	}
+	return m, hl + n, nil
+}
+
+// MqttReader reads control packets from a stream, such as a TCP connection
+// reassembled by TcpStreams and handed over through an io.Pipe.
+type MqttReader struct {
+	MaxPacket int // longest control packet accepted, 1MiB if zero
+
+	r *bufio.Reader
+}
+
+// NewMqttReader creates an MqttReader that reads from r.
+func NewMqttReader(r *bufio.Reader) *MqttReader {
+	return &MqttReader{r: r}
+}
+
+func (m *MqttReader) maxPacket() int {
+	if m.MaxPacket > 0 {
+		return m.MaxPacket
+	}
+	return 1 << 20
+}
+
+// ReadMqtt reads one control packet from a stream, with the packet size
+// limit of MqttReader.
+func ReadMqtt(r *bufio.Reader) (*MqttPacket, error) {
+	return NewMqttReader(r).Next()
+}
+
+// Next reads the next control packet. A packet longer than MaxPacket is
+// discarded without being buffered and ErrFrameTooLong is returned, so
+// that reading can continue with the packet that follows it.
+func (m *MqttReader) Next() (*MqttPacket, error) {
+	hdr, err := m.r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	for i := 2; ; i++ {
+		n, err := MqttLen(hdr)
+		if err == nil {
+			if n > m.maxPacket() {
+				// An error here ends the stream, which the next call reports.
+				m.r.Discard(n)
+				return nil, ErrFrameTooLong
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(m.r, buf); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			p, _, err := DecodeMqtt(buf)
+			return p, err
+		}
+		if err != ErrShortFrame {
+			return nil, err
+		}
+		if hdr, err = m.r.Peek(i + 1); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+}
+
+// unexpectedEOF turns io.EOF within a packet into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func mqttString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errMqtt
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errMqtt
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func (m *MqttPacket) decode(b []byte) error {
+	var err error
+	switch m.Type {
+	case MQTT_CONNECT:
+		if m.ProtocolName, b, err = mqttString(b); err != nil {
+			return err
+		}
+		if len(b) < 4 {
+			return errMqtt
+		}
+		m.ProtocolLevel = b[0]
+		m.ConnectFlags = b[1]
+		m.KeepAlive = binary.BigEndian.Uint16(b[2:4])
+		b = b[4:]
+		if m.ProtocolLevel >= 5 {
+			// Skip the connect properties.
+			n, size, err := mqttVarint(b)
+			if err != nil || len(b) < size+n {
+				return errMqtt
+			}
+			b = b[size+n:]
+		}
+		if m.ClientId, _, err = mqttString(b); err != nil {
+			return err
+		}
+	case MQTT_CONNACK:
+		if len(b) < 2 {
+			return errMqtt
+		}
+		m.SessionPresent = b[0]&0x01 != 0
+		m.ReturnCode = b[1]
+	case MQTT_PUBLISH:
+		if m.Topic, b, err = mqttString(b); err != nil {
+			return err
+		}
+		if m.Qos() > 0 {
+			if len(b) < 2 {
+				return errMqtt
+			}
+			m.PacketId = binary.BigEndian.Uint16(b)
+			b = b[2:]
+		}
+		m.Payload = b
+	case MQTT_PUBACK, MQTT_PUBREC, MQTT_PUBREL, MQTT_PUBCOMP, MQTT_UNSUBACK:
+		if len(b) < 2 {
+			return errMqtt
+		}
+		m.PacketId = binary.BigEndian.Uint16(b)
+	case MQTT_SUBSCRIBE, MQTT_UNSUBSCRIBE:
+		if len(b) < 2 {
+			return errMqtt
+		}
+		m.PacketId = binary.BigEndian.Uint16(b)
+		b = b[2:]
+		for len(b) > 0 {
+			var topic string
+			if topic, b, err = mqttString(b); err != nil {
+				return err
+			}
+			m.Topics = append(m.Topics, topic)
+			if m.Type == MQTT_SUBSCRIBE {
+				if len(b) < 1 {
+					return errMqtt
+				}
+				m.Qoss = append(m.Qoss, b[0])
+				b = b[1:]
+			}
+		}
+	case MQTT_SUBACK:
+		if len(b) < 2 {
+			return errMqtt
+		}
+		m.PacketId = binary.BigEndian.Uint16(b)
+		m.Qoss = b[2:]
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestMqttReaderMaxPacket(t *testing.T) {
+	ping := []byte{MQTT_PINGREQ << 4, 0}
+	publish := append([]byte{MQTT_PUBLISH << 4, 0x85, 0x01, 0, 1, 't'}, make([]byte, 130)...) // 136 bytes
+	tests := []struct {
+		name   string
+		max    int
+		stream [][]byte
+		want   []error // result of each call to Next
+	}{
+		{"within limit", 0, [][]byte{publish, ping}, []error{nil, nil, io.EOF}},
+		{"skips oversized packet", 100, [][]byte{ping, publish, ping}, []error{nil, ErrFrameTooLong, nil, io.EOF}},
+		{"at limit", 136, [][]byte{publish}, []error{nil, io.EOF}},
+		{"garbage length", 0, [][]byte{{0x30, 0xff, 0xff, 0xff, 0x7f, 0x00, 0x01}}, []error{ErrFrameTooLong, io.EOF}},
+		{"truncated packet", 0, [][]byte{publish[:50]}, []error{io.ErrUnexpectedEOF}},
+		{"truncated length", 0, [][]byte{{0x30, 0xff}}, []error{io.ErrUnexpectedEOF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewMqttReader(bufio.NewReader(bytes.NewReader(bytes.Join(tt.stream, nil))))
+			r.MaxPacket = tt.max
+			for i, want := range tt.want {
+				if _, err := r.Next(); err != want {
+					t.Fatalf("packet %d: error %v, want %v", i, err, want)
+				}
+			}
+		})
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"time"
 )
 
+// PacketTime is a timestamp in seconds and microseconds.
+//
+// Deprecated: use Timestamp, which also carries nanosecond timestamps.
 type PacketTime struct {
 	Sec  int32
 	Usec int32
@@ -19,6 +22,11 @@ type Packet struct {
 	Caplen uint32    // bytes stored in the file (caplen <= len)
 	Len    uint32    // bytes sent/received
 
+	// Timestamp holds the time as read from the file, zero for packets
+	// that were not read by a Reader. Writers use it instead of Time when
+	// it still denotes the same instant.
+	Timestamp Timestamp
+
 	Data []byte // packet data
 	PacketData  *PacketData
 	Pool				*sync.Pool
@@ -27,19 +35,42 @@ type Packet struct {
 	DestMac uint64
 	SrcMac  uint64
 
-	// We only care about IP and UDP headers for pcap
+	// We only care about IP, TCP and UDP headers for pcap
 	Iphdr   Iphdr
+	Ip6hdr  Ip6hdr
+	Tcphdr  Tcphdr
 	Udphdr  Udphdr
+	Sctphdr Sctphdr
 	Payload []byte // remaining non-header bytes
+
+	// Outer headers of IP-in-IP, 6in4 and Teredo packets, outermost first.
+	// The headers above describe the innermost packet.
+	Tunnels []Tunnel
+
+	// Link layer control PDUs, nil unless present.
+	Llchdr    Llchdr // IEEE 802.3 frames only, see ETHER_MAX_LEN
+	Bpdu      *Bpdu
+	Eapolhdr  *Eapolhdr
+	Macsechdr *Macsechdr // outer SecTAG; Type is the inner EtherType when not encrypted
+	Vrrphdr   *Vrrphdr
+	Hsrphdr   *Hsrphdr
 }
 
+// Free returns the packet buffer to its pool. Packets built without a
+// pool, such as copies, have nothing to free.
 func (p *Packet) Free() {
+	if p.Pool == nil || p.PacketData == nil {
+		return
+	}
 	//fmt.Printf("free %p\n", p.PacketData)
 	p.Pool.Put(p.PacketData)
 }
 
-// Decode decodes the headers of a Packet.
+// Decode decodes the headers of a Packet. Headers left from an earlier
+// call are cleared first, so a packet can be decoded again after its Data
+// was changed.
 func (p *Packet) Decode() error {
+	p.reset()
 	if len(p.Data) <= 14 {
 		return errors.New("invalid header")
 	}
@@ -47,13 +78,45 @@ func (p *Packet) Decode() error {
 	p.DestMac = decodemac(p.Data[0:6])
 	p.SrcMac = decodemac(p.Data[6:12])
 	p.Payload = p.Data[14:]
+	p.decodeEthertype()
+
+	return nil
+}
+
+// reset clears the decoded headers.
+func (p *Packet) reset() {
+	p.Type, p.DestMac, p.SrcMac = 0, 0, 0
+	p.Iphdr = Iphdr{}
+	p.Ip6hdr = Ip6hdr{}
+	p.Tcphdr = Tcphdr{}
+	p.Udphdr = Udphdr{}
+	p.Sctphdr = Sctphdr{}
+	p.Payload = nil
+	p.Tunnels = p.Tunnels[:0]
+	p.Llchdr = Llchdr{}
+	p.Bpdu, p.Eapolhdr, p.Macsechdr = nil, nil, nil
+	p.Vrrphdr, p.Hsrphdr = nil, nil
+}
 
+// decodeEthertype decodes the payload according to p.Type.
+func (p *Packet) decodeEthertype() {
 	switch p.Type {
 	case TYPE_IP:
 		p.decodeIp()
+	case TYPE_IP6:
+		p.decodeIp6()
+	case TYPE_EAPOL:
+		p.decodeEapol()
+	case TYPE_MACSEC:
+		p.decodeMacsec()
+	default:
+		if p.Type <= ETHER_MAX_LEN {
+			if p.Type < len(p.Payload) {
+				p.Payload = p.Payload[:p.Type]
+			}
+			p.decodeLlc()
+		}
 	}
-
-	return nil
 }
 
 func (p *Packet) decodeIp() {
@@ -75,6 +138,7 @@ func (p *Packet) decodeIp() {
 	p.Iphdr.Checksum = binary.BigEndian.Uint16(pkt[10:12])
 	p.Iphdr.SrcIp = pkt[12:16]
 	p.Iphdr.DestIp = pkt[16:20]
+	p.Iphdr.raw = nil
 	pEnd := int(p.Iphdr.Length)
 	if pEnd > len(pkt) {
 		pEnd = len(pkt)
@@ -83,12 +147,55 @@ func (p *Packet) decodeIp() {
 	if pIhl > pEnd {
 		pIhl = pEnd
 	}
+	if pIhl >= 20 {
+		p.Iphdr.raw = pkt[:pIhl]
+	}
 	p.Payload = pkt[pIhl:pEnd]
+	p.decodeIpProto(p.Iphdr.Protocol)
+}
 
-	switch p.Iphdr.Protocol {
+// decodeIpProto decodes the payload of an IPv4 or IPv6 packet.
+func (p *Packet) decodeIpProto(proto uint8) {
+	switch proto {
+	case IP_TCP:
+		p.decodeTcp()
 	case IP_UDP:
 		p.decodeUdp()
+	case IP_VRRP:
+		p.decodeVrrp()
+	case IP_SCTP:
+		p.decodeSctp()
+	case IP_INIP:
+		p.decodeTunnel(proto, 4)
+	case IP_IPV6:
+		p.decodeTunnel(proto, 6)
+	}
+}
+
+func (p *Packet) decodeTcp() {
+	if len(p.Payload) < 20 {
+		return
+	}
+	pkt := p.Payload
+	p.Tcphdr.SrcPort = binary.BigEndian.Uint16(pkt[0:2])
+	p.Tcphdr.DestPort = binary.BigEndian.Uint16(pkt[2:4])
+	p.Tcphdr.Seq = binary.BigEndian.Uint32(pkt[4:8])
+	p.Tcphdr.Ack = binary.BigEndian.Uint32(pkt[8:12])
+	p.Tcphdr.DataOffset = pkt[12] >> 4
+	p.Tcphdr.Flags = binary.BigEndian.Uint16(pkt[12:14]) & 0x01FF
+	p.Tcphdr.Window = binary.BigEndian.Uint16(pkt[14:16])
+	p.Tcphdr.Checksum = binary.BigEndian.Uint16(pkt[16:18])
+	p.Tcphdr.Urgent = binary.BigEndian.Uint16(pkt[18:20])
+	pOff := int(p.Tcphdr.DataOffset) * 4
+	if pOff < 20 {
+		pOff = 20
 	}
+	if pOff > len(pkt) {
+		pOff = len(pkt)
+	}
+	p.Tcphdr.Options = pkt[20:pOff]
+	p.Tcphdr.Data = pkt[pOff:]
+	p.Payload = pkt[pOff:]
 }
 
 func (p *Packet) decodeUdp() {
@@ -101,4 +208,11 @@ func (p *Packet) decodeUdp() {
 	p.Udphdr.Length = binary.BigEndian.Uint16(pkt[4:6])
 	p.Udphdr.Checksum = binary.BigEndian.Uint16(pkt[6:8])
 	p.Payload = pkt[8:]
+
+	switch {
+	case p.Udphdr.SrcPort == HSRP_PORT || p.Udphdr.DestPort == HSRP_PORT:
+		p.decodeHsrp()
+	case p.Udphdr.SrcPort == TEREDO_PORT || p.Udphdr.DestPort == TEREDO_PORT:
+		p.decodeTeredo()
+	}
 }
@@ -0,0 +1,76 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// ethernet returns an Ethernet frame carrying payload.
+func ethernet(etherType uint16, payload []byte) []byte {
+	b := make([]byte, 14)
+	binary.BigEndian.PutUint16(b[12:], etherType)
+	return append(b, payload...)
+}
+
+// ipv4 returns an IPv4 packet carrying payload.
+func ipv4(proto uint8, payload []byte) []byte {
+	b := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, proto, 0, 0, 192, 0, 2, 1, 192, 0, 2, 2}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)+len(payload)))
+	return append(b, payload...)
+}
+
+// ipv6 returns an IPv6 packet carrying payload.
+func ipv6(next uint8, payload []byte) []byte {
+	b := make([]byte, 40)
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:], uint16(len(payload)))
+	b[6], b[7] = next, 64
+	b[8], b[23], b[24], b[39] = 0x20, 1, 0x20, 2
+	return append(b, payload...)
+}
+
+func TestPacketDecodeAgain(t *testing.T) {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:], 40000)
+	binary.BigEndian.PutUint16(tcp[2:], 80)
+	tcp[12] = 5 << 4
+	vrrp := []byte{0x31, 1, 100, 1, 0, 100, 0, 0, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	frames := map[string][]byte{
+		"tcp":     ethernet(TYPE_IP, ipv4(IP_TCP, tcp)),
+		"6in4":    ethernet(TYPE_IP, ipv4(IP_IPV6, ipv6(IP_TCP, tcp))),
+		"vrrp6":   ethernet(TYPE_IP6, ipv6(IP_VRRP, vrrp)),
+		"ip6 tcp": ethernet(TYPE_IP6, ipv6(IP_TCP, tcp)),
+	}
+	tests := []struct{ first, second string }{
+		{"6in4", "6in4"},
+		{"tcp", "tcp"},
+		{"vrrp6", "tcp"},
+		{"6in4", "tcp"},
+		{"ip6 tcp", "tcp"},
+		{"tcp", "vrrp6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.first+" then "+tt.second, func(t *testing.T) {
+			want := &Packet{Data: frames[tt.second]}
+			if err := want.Decode(); err != nil {
+				t.Fatal(err)
+			}
+			p := &Packet{Data: frames[tt.first]}
+			if err := p.Decode(); err != nil {
+				t.Fatal(err)
+			}
+			p.Data = frames[tt.second]
+			if err := p.Decode(); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Tunnels) != len(want.Tunnels) {
+				t.Errorf("%d tunnels, want %d", len(p.Tunnels), len(want.Tunnels))
+			}
+			p.Tunnels, want.Tunnels = nil, nil
+			if !reflect.DeepEqual(p, want) {
+				t.Errorf("got  %+v\nwant %+v", p, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// DSCP code points, RFC 2474, RFC 2597 and RFC 3246.
+const (
+	DSCP_CS0  = 0
+	DSCP_CS1  = 8
+	DSCP_AF11 = 10
+	DSCP_AF12 = 12
+	DSCP_AF13 = 14
+	DSCP_CS2  = 16
+	DSCP_AF21 = 18
+	DSCP_AF22 = 20
+	DSCP_AF23 = 22
+	DSCP_CS3  = 24
+	DSCP_AF31 = 26
+	DSCP_AF32 = 28
+	DSCP_AF33 = 30
+	DSCP_CS4  = 32
+	DSCP_AF41 = 34
+	DSCP_AF42 = 36
+	DSCP_AF43 = 38
+	DSCP_CS5  = 40
+	DSCP_EF   = 46
+	DSCP_CS6  = 48
+	DSCP_CS7  = 56
+)
+
+// ECN code points, RFC 3168.
+const (
+	ECN_NOT_ECT = 0
+	ECN_ECT1    = 1
+	ECN_ECT0    = 2
+	ECN_CE      = 3
+)
+
+var dscpNames = map[uint8]string{
+	DSCP_CS0: "CS0", DSCP_CS1: "CS1", DSCP_AF11: "AF11", DSCP_AF12: "AF12",
+	DSCP_AF13: "AF13", DSCP_CS2: "CS2", DSCP_AF21: "AF21", DSCP_AF22: "AF22",
+	DSCP_AF23: "AF23", DSCP_CS3: "CS3", DSCP_AF31: "AF31", DSCP_AF32: "AF32",
+	DSCP_AF33: "AF33", DSCP_CS4: "CS4", DSCP_AF41: "AF41", DSCP_AF42: "AF42",
+	DSCP_AF43: "AF43", DSCP_CS5: "CS5", DSCP_EF: "EF", DSCP_CS6: "CS6",
+	DSCP_CS7: "CS7",
+}
+
+var ecnNames = [4]string{"Not-ECT", "ECT(1)", "ECT(0)", "CE"}
+
+// DscpString returns the name of a DSCP code point, or its number if it
+// has none.
+func DscpString(dscp uint8) string {
+	if s, ok := dscpNames[dscp]; ok {
+		return s
+	}
+	return fmt.Sprintf("DSCP%d", dscp)
+}
+
+// EcnString returns the name of an ECN code point.
+func EcnString(ecn uint8) string {
+	return ecnNames[ecn&0x03]
+}
+
+// Dscp returns the Differentiated Services code point of the header.
+func (ip *Iphdr) Dscp() uint8 { return ip.Tos >> 2 }
+
+// Ecn returns the Explicit Congestion Notification bits of the header.
+func (ip *Iphdr) Ecn() uint8 { return ip.Tos & 0x03 }
+
+// SetTos rewrites the TOS byte of a decoded IPv4 header in place and
+// updates the header checksum to match.
+func (ip *Iphdr) SetTos(tos uint8) error {
+	if len(ip.raw) < 20 {
+		return errors.New("pcap: ip header not decoded")
+	}
+	ip.raw[1] = tos
+	ip.Tos = tos
+	ip.Checksum = ipChecksum(ip.raw)
+	binary.BigEndian.PutUint16(ip.raw[10:12], ip.Checksum)
+	return nil
+}
+
+// Dscp returns the Differentiated Services code point of the header.
+func (ip *Ip6hdr) Dscp() uint8 { return ip.TrafficClass >> 2 }
+
+// Ecn returns the Explicit Congestion Notification bits of the header.
+func (ip *Ip6hdr) Ecn() uint8 { return ip.TrafficClass & 0x03 }
+
+// SetTrafficClass rewrites the Traffic Class of a decoded IPv6 header in
+// place. IPv6 has no header checksum, so nothing else changes.
+func (ip *Ip6hdr) SetTrafficClass(tc uint8) error {
+	if len(ip.raw) < 40 {
+		return errors.New("pcap: ip6 header not decoded")
+	}
+	ip.raw[0] = ip.raw[0]&0xf0 | tc>>4
+	ip.raw[1] = tc<<4 | ip.raw[1]&0x0f
+	ip.TrafficClass = tc
+	return nil
+}
+
+// ipChecksum computes the checksum of an IPv4 header, skipping the
+// checksum field itself.
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		if i == 10 {
+			continue
+		}
+		sum += uint32(hdr[i])<<8 | uint32(hdr[i+1])
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// QosStats aggregates DSCP and ECN markings over decoded IP packets.
+type QosStats struct {
+	Packets uint64 // IP packets counted
+	Bytes   uint64 // IP bytes counted, from the header length field
+
+	DscpPackets [64]uint64
+	DscpBytes   [64]uint64
+	EcnPackets  [4]uint64
+	EcnBytes    [4]uint64
+}
+
+// Add counts the markings of the innermost IP header of a decoded packet.
+// Packets without an IP header are ignored.
+func (s *QosStats) Add(p *Packet) {
+	var n uint64
+	var dscp, ecn uint8
+	switch {
+	case p.Iphdr.Version == 4:
+		n = uint64(p.Iphdr.Length)
+		dscp, ecn = p.Iphdr.Dscp(), p.Iphdr.Ecn()
+	case p.Ip6hdr.Version == 6:
+		n = uint64(p.Ip6hdr.Len())
+		dscp, ecn = p.Ip6hdr.Dscp(), p.Ip6hdr.Ecn()
+	default:
+		return
+	}
+	s.Packets++
+	s.Bytes += n
+	s.DscpPackets[dscp]++
+	s.DscpBytes[dscp] += n
+	s.EcnPackets[ecn]++
+	s.EcnBytes[ecn] += n
+}
+
+// String returns a table of the non-empty DSCP and ECN counters.
+func (s *QosStats) String() string {
+	out := fmt.Sprintf("packets=%d bytes=%d\n", s.Packets, s.Bytes)
+	for dscp, n := range s.DscpPackets {
+		if n != 0 {
+			out += fmt.Sprintf("%-8s packets=%d bytes=%d\n",
+				DscpString(uint8(dscp)), n, s.DscpBytes[dscp])
+		}
+	}
+	for ecn, n := range s.EcnPackets {
+		if n != 0 {
+			out += fmt.Sprintf("%-8s packets=%d bytes=%d\n",
+				EcnString(uint8(ecn)), n, s.EcnBytes[ecn])
+		}
+	}
+	return out
+}
+
+// QosRewrite sets the DSCP and ECN markings of IP packets.
+type QosRewrite struct {
+	// DscpMap remaps DSCP values, keyed by the original code point.
+	// It is applied before SetDscp.
+	DscpMap map[uint8]uint8
+
+	SetDscp bool  // overwrite every DSCP with Dscp
+	Dscp    uint8 // 0-63
+	SetEcn  bool  // overwrite every ECN field with Ecn
+	Ecn     uint8 // 0-3
+}
+
+// Apply rewrites the markings of the innermost IP header of a decoded
+// packet in place. It reports whether the packet was modified; packets
+// without an IP header are left untouched.
+func (q *QosRewrite) Apply(p *Packet) (bool, error) {
+	var old uint8
+	switch {
+	case p.Iphdr.Version == 4:
+		old = p.Iphdr.Tos
+	case p.Ip6hdr.Version == 6:
+		old = p.Ip6hdr.TrafficClass
+	default:
+		return false, nil
+	}
+	if q.Dscp > 63 || q.Ecn > 3 {
+		return false, fmt.Errorf("pcap: bad qos marking: dscp=%d ecn=%d", q.Dscp, q.Ecn)
+	}
+	dscp, ecn := old>>2, old&0x03
+	if d, ok := q.DscpMap[dscp]; ok {
+		dscp = d & 0x3f
+	}
+	if q.SetDscp {
+		dscp = q.Dscp
+	}
+	if q.SetEcn {
+		ecn = q.Ecn
+	}
+	tos := dscp<<2 | ecn
+	if tos == old {
+		return false, nil
+	}
+	var err error
+	if p.Iphdr.Version == 4 {
+		err = p.Iphdr.SetTos(tos)
+	} else {
+		err = p.Ip6hdr.SetTrafficClass(tos)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
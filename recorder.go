@@ -0,0 +1,114 @@
+package pcap
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Record describes one packet written by a Recorder, with the position of
+// its record in the pcap file.
+type Record struct {
+	Packet int       `json:"packet"` // packet number, from 1 as in wireshark's frame.number
+	Offset int64     `json:"offset"` // file offset of the packet record header
+	Time   time.Time `json:"time"`
+	Caplen uint32    `json:"caplen"`
+	Len    uint32    `json:"len"`
+
+	Decoded interface{} `json:"decoded,omitempty"`
+}
+
+// RecordWriter stores records, for example as JSON lines or in a columnar
+// format provided by the caller.
+type RecordWriter interface {
+	WriteRecord(r *Record) error
+}
+
+type jsonRecordWriter struct {
+	enc *json.Encoder
+}
+
+// NewJsonRecordWriter creates a RecordWriter that writes one JSON object
+// per line.
+func NewJsonRecordWriter(w io.Writer) RecordWriter {
+	return &jsonRecordWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonRecordWriter) WriteRecord(r *Record) error { return j.enc.Encode(r) }
+
+// PacketSummary is the decoded data recorded when a Recorder has no
+// Decode function.
+type PacketSummary struct {
+	Src        string `json:"src,omitempty"`
+	Dest       string `json:"dest,omitempty"`
+	Protocol   uint8  `json:"protocol,omitempty"` // see IP_*
+	SrcPort    uint16 `json:"src_port,omitempty"`
+	DestPort   uint16 `json:"dest_port,omitempty"`
+	PayloadLen int    `json:"payload_len"`
+	Tunnels    int    `json:"tunnels,omitempty"`
+}
+
+// Summarize returns the addresses and ports of the innermost IP packet of
+// a decoded packet.
+func Summarize(p *Packet) interface{} {
+	s := &PacketSummary{PayloadLen: len(p.Payload), Tunnels: len(p.Tunnels)}
+	switch {
+	case p.Ip6hdr.Version == 6:
+		s.Src, s.Dest, s.Protocol = p.Ip6hdr.SrcAddr(), p.Ip6hdr.DestAddr(), p.Ip6hdr.Protocol
+	case p.Iphdr.Version == 4:
+		s.Src, s.Dest, s.Protocol = p.Iphdr.SrcAddr(), p.Iphdr.DestAddr(), p.Iphdr.Protocol
+	default:
+		return s
+	}
+	switch s.Protocol {
+	case IP_TCP:
+		s.SrcPort, s.DestPort = p.Tcphdr.SrcPort, p.Tcphdr.DestPort
+	case IP_UDP:
+		s.SrcPort, s.DestPort = p.Udphdr.SrcPort, p.Udphdr.DestPort
+	case IP_SCTP:
+		s.SrcPort, s.DestPort = p.Sctphdr.SrcPort, p.Sctphdr.DestPort
+	}
+	return s
+}
+
+// Recorder writes packets to a pcap file and, aligned with it, a record of
+// their decoded data, so that a query over the records leads back to the
+// exact packets with the packet number or file offset.
+type Recorder struct {
+	Writer  *Writer
+	Records RecordWriter
+
+	// Decode returns the decoded data of a packet, Summarize if nil. It
+	// is called after the packet was written and may keep the result, but
+	// not the packet.
+	Decode func(*Packet) interface{}
+
+	Count int // packets written
+}
+
+// NewRecorder creates a Recorder writing to w and records.
+func NewRecorder(w *Writer, records RecordWriter, decode func(*Packet) interface{}) *Recorder {
+	return &Recorder{Writer: w, Records: records, Decode: decode}
+}
+
+// Write writes a decoded packet to the pcap file and its record. The record
+// is only written once the packet is.
+func (r *Recorder) Write(p *Packet) error {
+	offset := r.Writer.Offset()
+	if err := r.Writer.Write(p); err != nil {
+		return err
+	}
+	r.Count++
+	decode := r.Decode
+	if decode == nil {
+		decode = Summarize
+	}
+	return r.Records.WriteRecord(&Record{
+		Packet:  r.Count,
+		Offset:  offset,
+		Time:    r.Writer.LastTimestamp().Time(),
+		Caplen:  p.Caplen,
+		Len:     p.Len,
+		Decoded: decode(p),
+	})
+}
@@ -0,0 +1,266 @@
+package pcap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RotatingWriter writes packets to a sequence of pcap files, closing the
+// current file and starting the next one once it reaches one of the
+// limits. Closed files can be compressed in the background by a
+// Compressor.
+type RotatingWriter struct {
+	Header FileHeader
+
+	// Name returns the path of the seq-th file, from 1, whose first packet
+	// was captured at start. By default files are named
+	// <prefix>-20060102T150405-00001.pcap.
+	Name func(seq int, start time.Time) string
+
+	MaxBytes   int64         // file size limit, none if zero
+	MaxPackets int           // packets per file, none if zero
+	MaxAge     time.Duration // capture time spanned by a file, none if zero
+
+	Compressor *Compressor // compresses closed files, if set
+	Events     *EventBus   // receives EVENT_ROTATION for every closed file
+
+	file    *os.File
+	buf     *bufio.Writer
+	w       *Writer
+	path    string
+	seq     int
+	start   time.Time
+	packets int
+}
+
+// NewRotatingWriter creates a RotatingWriter of files named after prefix.
+// The first file is created by the first Write.
+func NewRotatingWriter(prefix string, header *FileHeader) *RotatingWriter {
+	return &RotatingWriter{
+		Header: *header,
+		Name: func(seq int, start time.Time) string {
+			return fmt.Sprintf("%s-%s-%05d.pcap", prefix, start.UTC().Format("20060102T150405"), seq)
+		},
+	}
+}
+
+// Path returns the path of the file being written, empty before the
+// first packet.
+func (r *RotatingWriter) Path() string { return r.path }
+
+// Write writes a packet, starting a new file first if the current one is
+// full.
+func (r *RotatingWriter) Write(pkt *Packet) error {
+	if r.w == nil || r.full(pkt) {
+		if err := r.rotate(pkt.Time); err != nil {
+			return err
+		}
+	}
+	if err := r.w.Write(pkt); err != nil {
+		return err
+	}
+	r.packets++
+	return nil
+}
+
+func (r *RotatingWriter) full(pkt *Packet) bool {
+	switch {
+	case r.MaxPackets > 0 && r.packets >= r.MaxPackets:
+		return true
+	case r.MaxBytes > 0 && r.w.Offset()+16+int64(len(pkt.Data)) > r.MaxBytes && r.packets > 0:
+		return true
+	case r.MaxAge > 0 && pkt.Time.Sub(r.start) >= r.MaxAge:
+		return true
+	}
+	return false
+}
+
+func (r *RotatingWriter) rotate(start time.Time) error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	r.seq++
+	path := r.Name(r.seq, start)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	r.buf = bufio.NewWriterSize(f, 64<<10)
+	w, err := NewWriter(r.buf, &r.Header)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.w, r.path = f, w, path
+	r.start = start
+	r.packets = 0
+	return nil
+}
+
+// closeFile closes the current file and hands it to the compressor.
+func (r *RotatingWriter) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	f, path, packets := r.file, r.path, r.packets
+	r.file, r.w, r.path = nil, nil, ""
+	err := r.buf.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	r.Events.Publish(&Event{
+		Kind:    EVENT_ROTATION,
+		Source:  "rotate",
+		Time:    r.start,
+		Count:   int64(packets),
+		Message: path,
+	})
+	if r.Compressor != nil {
+		r.Compressor.Submit(path)
+	}
+	return nil
+}
+
+// Close closes the current file and waits for the compression of every
+// closed file to finish. The Compressor is closed with it.
+func (r *RotatingWriter) Close() error {
+	err := r.closeFile()
+	if r.Compressor != nil {
+		r.Compressor.Close()
+	}
+	return err
+}
+
+// CompressFunc compresses src into dst.
+type CompressFunc func(dst io.Writer, src io.Reader) error
+
+// GzipCompress is a CompressFunc producing gzip files.
+func GzipCompress(dst io.Writer, src io.Reader) error {
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// CompressResult reports the compression of one file.
+type CompressResult struct {
+	Source   string
+	Dest     string
+	InBytes  int64
+	OutBytes int64
+	Duration time.Duration
+	Err      error
+}
+
+// Compressor compresses files on a fixed number of background workers.
+// The compressed file is written next to the source with a suffix, and
+// the source is removed once it is complete. Formats other than gzip,
+// such as zstd, are provided with a CompressFunc.
+type Compressor struct {
+	compress CompressFunc
+	suffix   string
+	done     func(CompressResult)
+	jobs     chan string
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// NewCompressor starts workers goroutines, 1 if zero, compressing with
+// compress, GzipCompress and ".gz" if nil. Done, if not nil, is called
+// from a worker when a file is finished.
+func NewCompressor(workers int, compress CompressFunc, suffix string, done func(CompressResult)) *Compressor {
+	if workers <= 0 {
+		workers = 1
+	}
+	if compress == nil {
+		compress, suffix = GzipCompress, ".gz"
+	}
+	c := &Compressor{
+		compress: compress,
+		suffix:   suffix,
+		done:     done,
+		jobs:     make(chan string, workers),
+	}
+	c.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer c.wg.Done()
+			for path := range c.jobs {
+				res := c.file(path)
+				if c.done != nil {
+					c.done(res)
+				}
+			}
+		}()
+	}
+	return c
+}
+
+// Submit queues a file for compression. It blocks while every worker is
+// busy and as many files are already queued, so that a writer producing
+// files faster than they compress is slowed down rather than piling up.
+func (c *Compressor) Submit(path string) { c.jobs <- path }
+
+// Close waits for every queued file to be compressed and stops the
+// workers. Submit must not be called afterwards.
+func (c *Compressor) Close() {
+	c.once.Do(func() { close(c.jobs) })
+	c.wg.Wait()
+}
+
+func (c *Compressor) file(path string) (res CompressResult) {
+	res = CompressResult{Source: path, Dest: path + c.suffix}
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+	src, err := os.Open(path)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer src.Close()
+	tmp := res.Dest + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	in := &countingReader{r: src}
+	err = c.compress(dst, in)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, res.Dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		res.Err = err
+		return res
+	}
+	res.InBytes = in.n
+	if fi, err := os.Stat(res.Dest); err == nil {
+		res.OutBytes = fi.Size()
+	}
+	res.Err = os.Remove(path)
+	return res
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
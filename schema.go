@@ -0,0 +1,315 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema decodes fixed-layout binary messages into Go structs. The layout
+// is declared with `pcap` struct tags:
+//
+//	type AddOrder struct {
+//		Type      byte    `pcap:"offset=0"`
+//		Timestamp uint64  `pcap:"offset=5,len=6"`
+//		Side      byte
+//		Shares    uint32
+//		Stock     string  `pcap:"len=8,trim"`
+//		Price     uint32  `pcap:"le"`
+//		Reserved  [4]byte
+//		Note      string  `pcap:"-"`
+//	}
+//
+// Fields follow each other unless offset= moves them. Integers use their
+// natural width, or len= bytes when the wire width is narrower. Strings and
+// byte slices need len=; trim strips trailing spaces and NULs. The options
+// be and le override the schema's byte order for one field, and - skips a
+// field. Nested structs are decoded in place.
+type Schema struct {
+	typ    reflect.Type
+	fields []schemaField
+	size   int
+}
+
+type schemaField struct {
+	index  []int
+	offset int
+	size   int
+	kind   reflect.Kind
+	order  binary.ByteOrder
+	trim   bool
+}
+
+// NewSchema compiles the layout of the struct type of v. Fields without an
+// explicit byte order use order, or big endian if order is nil.
+func NewSchema(v interface{}, order binary.ByteOrder) (*Schema, error) {
+	if order == nil {
+		order = binary.BigEndian
+	}
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pcap: schema needs a struct, got %v", typ)
+	}
+	s := &Schema{typ: typ}
+	size, err := s.compile(typ, nil, 0, order)
+	if err != nil {
+		return nil, err
+	}
+	s.size = size
+	return s, nil
+}
+
+// compile appends the fields of typ, starting at base, and returns the
+// offset following the furthest field.
+func (s *Schema) compile(typ reflect.Type, index []int, base int, order binary.ByteOrder) (int, error) {
+	off, end := base, base
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("pcap")
+		if tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		f := schemaField{
+			index: append(append([]int(nil), index...), i),
+			kind:  sf.Type.Kind(),
+			order: order,
+		}
+		f.size = -1
+		for _, opt := range strings.Split(tag, ",") {
+			key, val := opt, ""
+			if j := strings.IndexByte(opt, '='); j >= 0 {
+				key, val = opt[:j], opt[j+1:]
+			}
+			var err error
+			switch key {
+			case "":
+			case "offset":
+				off, err = strconv.Atoi(val)
+				if err == nil && off < 0 {
+					err = fmt.Errorf("negative offset %d", off)
+				}
+				off += base
+			case "len":
+				f.size, err = strconv.Atoi(val)
+				if err == nil && f.size < 1 {
+					err = fmt.Errorf("len %d is less than 1", f.size)
+				}
+			case "be":
+				f.order = binary.BigEndian
+			case "le":
+				f.order = binary.LittleEndian
+			case "trim":
+				f.trim = true
+			default:
+				err = fmt.Errorf("unknown option %q", key)
+			}
+			if err != nil {
+				return 0, fmt.Errorf("pcap: schema %s.%s: %v", typ.Name(), sf.Name, err)
+			}
+		}
+		f.offset = off
+
+		natural := 0
+		switch f.kind {
+		case reflect.Bool, reflect.Int8, reflect.Uint8:
+			natural = 1
+		case reflect.Int16, reflect.Uint16:
+			natural = 2
+		case reflect.Int32, reflect.Uint32, reflect.Float32:
+			natural = 4
+		case reflect.Int64, reflect.Uint64, reflect.Float64:
+			natural = 8
+		case reflect.Array:
+			if sf.Type.Elem().Kind() != reflect.Uint8 {
+				return 0, fmt.Errorf("pcap: schema %s.%s: only byte arrays are supported", typ.Name(), sf.Name)
+			}
+			natural = sf.Type.Len()
+		case reflect.Slice:
+			if sf.Type.Elem().Kind() != reflect.Uint8 {
+				return 0, fmt.Errorf("pcap: schema %s.%s: only byte slices are supported", typ.Name(), sf.Name)
+			}
+		case reflect.String:
+		case reflect.Struct:
+			n, err := s.compile(sf.Type, f.index, off, f.order)
+			if err != nil {
+				return 0, err
+			}
+			off = n
+			if off > end {
+				end = off
+			}
+			continue
+		default:
+			return 0, fmt.Errorf("pcap: schema %s.%s: unsupported type %v", typ.Name(), sf.Name, sf.Type)
+		}
+		switch {
+		case f.size < 0 && natural == 0:
+			return 0, fmt.Errorf("pcap: schema %s.%s: len is required", typ.Name(), sf.Name)
+		case f.size < 0:
+			f.size = natural
+		case f.kind == reflect.Float32 || f.kind == reflect.Float64:
+			if f.size != natural {
+				return 0, fmt.Errorf("pcap: schema %s.%s: floats must have their natural width", typ.Name(), sf.Name)
+			}
+		case natural != 0 && f.size > natural:
+			return 0, fmt.Errorf("pcap: schema %s.%s: len %d exceeds type width", typ.Name(), sf.Name, f.size)
+		}
+		s.fields = append(s.fields, f)
+		off += f.size
+		if off > end {
+			end = off
+		}
+	}
+	return end, nil
+}
+
+// Size returns the number of bytes a message needs to be decoded.
+func (s *Schema) Size() int { return s.size }
+
+// New decodes data into a newly allocated struct and returns a pointer to it.
+func (s *Schema) New(data []byte) (interface{}, error) {
+	v := reflect.New(s.typ)
+	if err := s.decode(data, v.Elem()); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// Decode decodes data into v, which must be a pointer to the schema's
+// struct type. Byte slices share the memory of data.
+func (s *Schema) Decode(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Type() != s.typ {
+		return fmt.Errorf("pcap: schema decodes *%v, got %T", s.typ, v)
+	}
+	return s.decode(data, rv.Elem())
+}
+
+func (s *Schema) decode(data []byte, v reflect.Value) error {
+	if len(data) < s.size {
+		return fmt.Errorf("pcap: %s needs %d bytes, got %d", s.typ.Name(), s.size, len(data))
+	}
+	for i := range s.fields {
+		f := &s.fields[i]
+		b := data[f.offset : f.offset+f.size]
+		fv := v.FieldByIndex(f.index)
+		switch f.kind {
+		case reflect.Bool:
+			fv.SetBool(b[0] != 0)
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fv.SetUint(schemaUint(b, f.order))
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			u := schemaUint(b, f.order)
+			shift := uint(64 - 8*len(b))
+			fv.SetInt(int64(u<<shift) >> shift)
+		case reflect.Float32:
+			fv.SetFloat(float64(math.Float32frombits(f.order.Uint32(b))))
+		case reflect.Float64:
+			fv.SetFloat(math.Float64frombits(f.order.Uint64(b)))
+		case reflect.Array:
+			reflect.Copy(fv, reflect.ValueOf(b))
+		case reflect.Slice:
+			fv.SetBytes(b)
+		case reflect.String:
+			if f.trim {
+				b = []byte(strings.TrimRight(string(b), " \x00"))
+			}
+			fv.SetString(string(b))
+		}
+	}
+	return nil
+}
+
+// schemaUint reads an unsigned integer of 1 to 8 bytes.
+func schemaUint(b []byte, order binary.ByteOrder) uint64 {
+	var v uint64
+	if order == binary.LittleEndian {
+		for i := len(b) - 1; i >= 0; i-- {
+			v = v<<8 | uint64(b[i])
+		}
+		return v
+	}
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// SchemaSet decodes messages of several types, distinguished by a type
+// field at a fixed position, such as the message type byte of an exchange
+// feed.
+type SchemaSet struct {
+	TypeOffset int              // position of the type field
+	TypeSize   int              // width of the type field, 1 if zero
+	Order      binary.ByteOrder // byte order of the type field and of the schemas
+
+	schemas map[uint64]*Schema
+}
+
+// Register adds the schema of the struct type of v for messages of type typ.
+func (s *SchemaSet) Register(typ uint64, v interface{}) error {
+	if _, err := s.typeSize(); err != nil {
+		return err
+	}
+	order := s.Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	schema, err := NewSchema(v, order)
+	if err != nil {
+		return err
+	}
+	if s.schemas == nil {
+		s.schemas = make(map[uint64]*Schema)
+	}
+	s.schemas[typ] = schema
+	return nil
+}
+
+// typeSize returns the width of the type field.
+func (s *SchemaSet) typeSize() (int, error) {
+	size := s.TypeSize
+	if size == 0 {
+		size = 1
+	}
+	if size < 0 || size > 8 || s.TypeOffset < 0 {
+		return 0, fmt.Errorf("pcap: bad type field: offset %d, size %d", s.TypeOffset, s.TypeSize)
+	}
+	return size, nil
+}
+
+// Type returns the type field of a message.
+func (s *SchemaSet) Type(data []byte) (uint64, error) {
+	size, err := s.typeSize()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < s.TypeOffset+size {
+		return 0, ErrShortFrame
+	}
+	order := s.Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	return schemaUint(data[s.TypeOffset:s.TypeOffset+size], order), nil
+}
+
+// Decode decodes a message with the schema registered for its type and
+// returns a pointer to a newly allocated struct.
+func (s *SchemaSet) Decode(data []byte) (interface{}, error) {
+	typ, err := s.Type(data)
+	if err != nil {
+		return nil, err
+	}
+	schema := s.schemas[typ]
+	if schema == nil {
+		return nil, fmt.Errorf("pcap: no schema for message type %d", typ)
+	}
+	return schema.New(data)
+}
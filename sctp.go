@@ -0,0 +1,389 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SCTP chunk types, RFC 4960 and RFC 3758.
+const (
+	SCTP_DATA              = 0
+	SCTP_INIT              = 1
+	SCTP_INIT_ACK          = 2
+	SCTP_SACK              = 3
+	SCTP_HEARTBEAT         = 4
+	SCTP_HEARTBEAT_ACK     = 5
+	SCTP_ABORT             = 6
+	SCTP_SHUTDOWN          = 7
+	SCTP_SHUTDOWN_ACK      = 8
+	SCTP_ERROR             = 9
+	SCTP_COOKIE_ECHO       = 10
+	SCTP_COOKIE_ACK        = 11
+	SCTP_SHUTDOWN_COMPLETE = 14
+	SCTP_FORWARD_TSN       = 192
+)
+
+// DATA chunk flags.
+const (
+	SCTP_FLAG_END       = 0x01
+	SCTP_FLAG_BEGINNING = 0x02
+	SCTP_FLAG_UNORDERED = 0x04
+)
+
+// SCTP_FLAG_TAG_REFLECTED is the T bit of ABORT and SHUTDOWN COMPLETE
+// chunks, set when the verification tag is the sender's own, RFC 4960.
+const SCTP_FLAG_TAG_REFLECTED = 0x01
+
+var sctpChunkNames = map[uint8]string{
+	SCTP_DATA: "DATA", SCTP_INIT: "INIT", SCTP_INIT_ACK: "INIT_ACK",
+	SCTP_SACK: "SACK", SCTP_HEARTBEAT: "HEARTBEAT", SCTP_HEARTBEAT_ACK: "HEARTBEAT_ACK",
+	SCTP_ABORT: "ABORT", SCTP_SHUTDOWN: "SHUTDOWN", SCTP_SHUTDOWN_ACK: "SHUTDOWN_ACK",
+	SCTP_ERROR: "ERROR", SCTP_COOKIE_ECHO: "COOKIE_ECHO", SCTP_COOKIE_ACK: "COOKIE_ACK",
+	SCTP_SHUTDOWN_COMPLETE: "SHUTDOWN_COMPLETE", SCTP_FORWARD_TSN: "FORWARD_TSN",
+}
+
+// Sctphdr is the common header of an SCTP packet and its chunks.
+type Sctphdr struct {
+	SrcPort         uint16
+	DestPort        uint16
+	VerificationTag uint32
+	Checksum        uint32
+	Chunks          []SctpChunk
+}
+
+// SctpChunk is one chunk of an SCTP packet. Value excludes the chunk
+// header and padding.
+type SctpChunk struct {
+	Type   uint8 // see SCTP_*
+	Flags  uint8
+	Length uint16
+	Value  []byte
+}
+
+// SctpData is the content of a DATA chunk.
+type SctpData struct {
+	Flags     uint8 // see SCTP_FLAG_*
+	Tsn       uint32
+	StreamId  uint16
+	StreamSeq uint16
+	Ppid      uint32 // payload protocol identifier
+	Data      []byte
+}
+
+func (d *SctpData) Unordered() bool { return d.Flags&SCTP_FLAG_UNORDERED != 0 }
+func (d *SctpData) Beginning() bool { return d.Flags&SCTP_FLAG_BEGINNING != 0 }
+func (d *SctpData) End() bool       { return d.Flags&SCTP_FLAG_END != 0 }
+
+// Data returns the content of a DATA chunk.
+func (c *SctpChunk) Data() (*SctpData, bool) {
+	if c.Type != SCTP_DATA || len(c.Value) < 12 {
+		return nil, false
+	}
+	return &SctpData{
+		Flags:     c.Flags,
+		Tsn:       binary.BigEndian.Uint32(c.Value[0:4]),
+		StreamId:  binary.BigEndian.Uint16(c.Value[4:6]),
+		StreamSeq: binary.BigEndian.Uint16(c.Value[6:8]),
+		Ppid:      binary.BigEndian.Uint32(c.Value[8:12]),
+		Data:      c.Value[12:],
+	}, true
+}
+
+func (sctp *Sctphdr) String(hdr addrHdr) string {
+	names := make([]string, len(sctp.Chunks))
+	for i, c := range sctp.Chunks {
+		name, ok := sctpChunkNames[c.Type]
+		if !ok {
+			name = fmt.Sprintf("type=%d", c.Type)
+		}
+		names[i] = name
+	}
+	return fmt.Sprintf("SCTP %s:%d > %s:%d VTAG=%#x [%s] LEN=%d",
+		hdr.SrcAddr(), int(sctp.SrcPort), hdr.DestAddr(), int(sctp.DestPort),
+		sctp.VerificationTag, strings.Join(names, " "), hdr.Len())
+}
+
+// decodeSctp decodes the common header and the chunks. Payload is set to
+// the user data of the first DATA chunk.
+func (p *Packet) decodeSctp() {
+	if len(p.Payload) < 12 {
+		return
+	}
+	pkt := p.Payload
+	p.Sctphdr.SrcPort = binary.BigEndian.Uint16(pkt[0:2])
+	p.Sctphdr.DestPort = binary.BigEndian.Uint16(pkt[2:4])
+	p.Sctphdr.VerificationTag = binary.BigEndian.Uint32(pkt[4:8])
+	p.Sctphdr.Checksum = binary.BigEndian.Uint32(pkt[8:12])
+	p.Sctphdr.Chunks = nil
+	p.Payload = pkt[12:12]
+	payload := false
+	for pkt = pkt[12:]; len(pkt) >= 4; {
+		n := int(binary.BigEndian.Uint16(pkt[2:4]))
+		if n < 4 || n > len(pkt) {
+			break
+		}
+		c := SctpChunk{Type: pkt[0], Flags: pkt[1], Length: uint16(n), Value: pkt[4:n]}
+		p.Sctphdr.Chunks = append(p.Sctphdr.Chunks, c)
+		if d, ok := c.Data(); ok && !payload {
+			p.Payload = d.Data
+			payload = true
+		}
+		n = (n + 3) &^ 3
+		if n > len(pkt) {
+			break
+		}
+		pkt = pkt[n:]
+	}
+}
+
+// SctpMessage is a user message reassembled from the DATA chunks of one
+// direction of an association.
+type SctpMessage struct {
+	Key             TcpFlowKey // addresses and ports of the last fragment
+	VerificationTag uint32
+	StreamId        uint16
+	StreamSeq       uint16 // meaningless for unordered messages
+	Ppid            uint32
+	Unordered       bool
+	FirstTsn        uint32
+	Fragments       int
+	Time            time.Time // time of the packet that completed the message
+	Data            []byte
+}
+
+func (m *SctpMessage) String() string {
+	return fmt.Sprintf("SCTP %s STREAM=%d SSN=%d PPID=%d TSN=%d FRAGS=%d LEN=%d",
+		m.Key, m.StreamId, m.StreamSeq, m.Ppid, m.FirstTsn, m.Fragments, len(m.Data))
+}
+
+// sctpAssocKey identifies one direction of an association independently of
+// the addresses, which may change on multihomed endpoints.
+type sctpAssocKey struct {
+	srcPort  uint16
+	destPort uint16
+	vtag     uint32
+}
+
+type sctpFragment struct {
+	flags     uint8
+	streamId  uint16
+	streamSeq uint16
+	ppid      uint32
+	data      []byte
+}
+
+type sctpAssociation struct {
+	frags  map[uint32]*sctpFragment // fragments awaiting the rest of their message
+	done   map[uint32]bool          // TSNs delivered, to drop retransmissions
+	high   uint32                   // highest TSN seen
+	synced bool
+}
+
+// SctpAssembler reassembles fragmented SCTP user messages by TSN, for every
+// association and stream of a capture. Complete messages are returned in
+// the order their last fragment arrived; reordering by stream sequence
+// number is left to the caller.
+type SctpAssembler struct {
+	// MaxFragments bounds the fragments buffered per association direction,
+	// 1024 if zero. The oldest fragments are dropped beyond it.
+	MaxFragments int
+	Dropped      uint64    // fragments dropped over the limit
+	Events       *EventBus // receives EVENT_DROP for every dropped fragment
+
+	assocs map[sctpAssocKey]*sctpAssociation
+	peers  map[sctpAssocKey]sctpAssocKey // the other direction, learnt from INIT ACK
+}
+
+// NewSctpAssembler creates an empty assembler.
+func NewSctpAssembler() *SctpAssembler {
+	return &SctpAssembler{
+		assocs: make(map[sctpAssocKey]*sctpAssociation),
+		peers:  make(map[sctpAssocKey]sctpAssocKey),
+	}
+}
+
+func (a *SctpAssembler) maxFragments() int {
+	if a.MaxFragments > 0 {
+		return a.MaxFragments
+	}
+	return 1024
+}
+
+// sctpFlowKey returns the addresses and ports of a decoded SCTP packet.
+func sctpFlowKey(p *Packet) (k TcpFlowKey, ok bool) {
+	if p.Sctphdr.SrcPort == 0 {
+		return k, false
+	}
+	return ipFlowKey(p, IP_SCTP, p.Sctphdr.SrcPort, p.Sctphdr.DestPort)
+}
+
+// Add accounts a decoded packet and returns the messages it completed.
+// Packets that are not SCTP are ignored. The data of the messages is
+// copied and outlives the packet.
+func (a *SctpAssembler) Add(p *Packet) []*SctpMessage {
+	k, ok := sctpFlowKey(p)
+	if !ok {
+		return nil
+	}
+	sctp := &p.Sctphdr
+	key := sctpAssocKey{sctp.SrcPort, sctp.DestPort, sctp.VerificationTag}
+	var msgs []*SctpMessage
+	for i := range sctp.Chunks {
+		c := &sctp.Chunks[i]
+		switch c.Type {
+		case SCTP_INIT_ACK:
+			a.initAck(key, c)
+			continue
+		case SCTP_ABORT, SCTP_SHUTDOWN_COMPLETE:
+			if c.Flags&SCTP_FLAG_TAG_REFLECTED != 0 {
+				a.close(sctpAssocKey{sctp.DestPort, sctp.SrcPort, sctp.VerificationTag})
+			} else {
+				a.close(key)
+			}
+			continue
+		case SCTP_DATA:
+		default:
+			continue
+		}
+		d, ok := c.Data()
+		if !ok {
+			continue
+		}
+		as := a.assocs[key]
+		if as == nil {
+			as = &sctpAssociation{
+				frags: make(map[uint32]*sctpFragment),
+				done:  make(map[uint32]bool),
+			}
+			a.assocs[key] = as
+		}
+		if m := a.add(as, d, p.Time); m != nil {
+			m.Key = k
+			m.VerificationTag = sctp.VerificationTag
+			m.Time = p.Time
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// initAck pairs the two directions of an association. The INIT ACK is
+// sent with the tag of the INIT, and its Initiate Tag is the one the other
+// endpoint uses from then on.
+func (a *SctpAssembler) initAck(key sctpAssocKey, c *SctpChunk) {
+	if len(c.Value) < 4 {
+		return
+	}
+	peer := sctpAssocKey{key.destPort, key.srcPort, binary.BigEndian.Uint32(c.Value[0:4])}
+	a.peers[key] = peer
+	a.peers[peer] = key
+}
+
+// close forgets a direction of an association, and the other direction if
+// its INIT ACK was seen. Without it the peer's tag is unknown, and the
+// other direction is kept until it is closed with its own tag.
+func (a *SctpAssembler) close(key sctpAssocKey) {
+	delete(a.assocs, key)
+	if peer, ok := a.peers[key]; ok {
+		delete(a.assocs, peer)
+		delete(a.peers, peer)
+		delete(a.peers, key)
+	}
+}
+
+func (a *SctpAssembler) add(as *sctpAssociation, d *SctpData, t time.Time) *SctpMessage {
+	tsn := d.Tsn
+	if as.done[tsn] || as.frags[tsn] != nil {
+		return nil
+	}
+	if !as.synced || seqGT(tsn, as.high) {
+		as.high = tsn
+		as.synced = true
+	}
+	as.frags[tsn] = &sctpFragment{
+		flags:     d.Flags,
+		streamId:  d.StreamId,
+		streamSeq: d.StreamSeq,
+		ppid:      d.Ppid,
+		data:      append([]byte{}, d.Data...),
+	}
+	m := as.assemble(tsn)
+	if m == nil && len(as.frags) > a.maxFragments() {
+		as.dropOldest()
+		a.Dropped++
+		a.Events.Publish(&Event{
+			Kind:    EVENT_DROP,
+			Source:  "sctp",
+			Time:    t,
+			Count:   1,
+			Message: "fragment limit reached",
+		})
+	}
+	as.prune(a.maxFragments())
+	return m
+}
+
+// assemble returns the message containing tsn if all its fragments have
+// arrived, and removes them.
+func (as *sctpAssociation) assemble(tsn uint32) *SctpMessage {
+	f := as.frags[tsn]
+	first := tsn
+	for as.frags[first].flags&SCTP_FLAG_BEGINNING == 0 {
+		prev := as.frags[first-1]
+		if prev == nil || prev.flags&SCTP_FLAG_END != 0 || prev.streamId != f.streamId {
+			return nil
+		}
+		first--
+	}
+	last := tsn
+	for as.frags[last].flags&SCTP_FLAG_END == 0 {
+		next := as.frags[last+1]
+		if next == nil || next.flags&SCTP_FLAG_BEGINNING != 0 || next.streamId != f.streamId {
+			return nil
+		}
+		last++
+	}
+	b := as.frags[first]
+	m := &SctpMessage{
+		StreamId:  b.streamId,
+		StreamSeq: b.streamSeq,
+		Ppid:      b.ppid,
+		Unordered: b.flags&SCTP_FLAG_UNORDERED != 0,
+		FirstTsn:  first,
+	}
+	for t := first; ; t++ {
+		m.Data = append(m.Data, as.frags[t].data...)
+		m.Fragments++
+		delete(as.frags, t)
+		as.done[t] = true
+		if t == last {
+			break
+		}
+	}
+	return m
+}
+
+func (as *sctpAssociation) dropOldest() {
+	var oldest uint32
+	found := false
+	for t := range as.frags {
+		if !found || seqLT(t, oldest) {
+			oldest, found = t, true
+		}
+	}
+	delete(as.frags, oldest)
+}
+
+// prune forgets delivered TSNs far behind the highest one seen.
+func (as *sctpAssociation) prune(window int) {
+	if len(as.done) <= 2*window {
+		return
+	}
+	for t := range as.done {
+		if seqLT(t, as.high-uint32(window)) {
+			delete(as.done, t)
+		}
+	}
+}
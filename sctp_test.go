@@ -0,0 +1,126 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// sctpPacket decodes an IPv4 SCTP packet from 10.0.0.1 to 10.0.0.2 holding
+// the given chunks.
+func sctpPacket(t *testing.T, srcPort, destPort uint16, vtag uint32, chunks ...[]byte) *Packet {
+	t.Helper()
+	sctp := make([]byte, 12)
+	binary.BigEndian.PutUint16(sctp[0:], srcPort)
+	binary.BigEndian.PutUint16(sctp[2:], destPort)
+	binary.BigEndian.PutUint32(sctp[4:], vtag)
+	for _, c := range chunks {
+		sctp = append(sctp, c...)
+	}
+	ip := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, IP_SCTP, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2}
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(sctp)))
+	data := append([]byte{12: 0x08, 13: 0x00}, ip...)
+	p := &Packet{Data: append(data, sctp...)}
+	if err := p.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func sctpDataChunk(flags uint8, tsn uint32) []byte {
+	c := make([]byte, 20)
+	c[0], c[1] = SCTP_DATA, flags
+	binary.BigEndian.PutUint16(c[2:], 20)
+	binary.BigEndian.PutUint32(c[4:], tsn)
+	copy(c[16:], "data")
+	return c
+}
+
+func sctpInitChunk(typ uint8, tag uint32) []byte {
+	c := make([]byte, 20)
+	c[0] = typ
+	binary.BigEndian.PutUint16(c[2:], 20)
+	binary.BigEndian.PutUint32(c[4:], tag)
+	return c
+}
+
+func sctpCloseChunk(typ, flags uint8) []byte { return []byte{typ, flags, 0, 4} }
+
+func TestSctpAssemblerClose(t *testing.T) {
+	const port = 2905 // M3UA, the same on every peer
+	type send struct {
+		srcPort, destPort uint16
+		vtag              uint32
+		chunk             []byte
+		messages          int
+	}
+	first := func(src, dst uint16, vtag, tsn uint32) send {
+		return send{src, dst, vtag, sctpDataChunk(SCTP_FLAG_BEGINNING, tsn), 0}
+	}
+	last := func(src, dst uint16, vtag, tsn uint32, messages int) send {
+		return send{src, dst, vtag, sctpDataChunk(SCTP_FLAG_END, tsn), messages}
+	}
+	tests := []struct {
+		name  string
+		sends []send
+	}{
+		{"abort keeps other associations on the same ports", []send{
+			first(port, port, 0xa1, 10),
+			first(port, port, 0xb1, 20),
+			{port, port, 0xa1, sctpCloseChunk(SCTP_ABORT, 0), 0},
+			last(port, port, 0xa1, 11, 0),
+			last(port, port, 0xb1, 21, 1),
+		}},
+		{"abort closes the peer direction learnt from INIT ACK", []send{
+			{port, port + 1, 0, sctpInitChunk(SCTP_INIT, 0xa2), 0},
+			{port + 1, port, 0xa2, sctpInitChunk(SCTP_INIT_ACK, 0xb2), 0},
+			first(port+1, port, 0xa2, 10),
+			first(port, port+1, 0xb2, 20),
+			{port, port + 1, 0xb2, sctpCloseChunk(SCTP_ABORT, 0), 0},
+			last(port+1, port, 0xa2, 11, 0),
+			last(port, port+1, 0xb2, 21, 0),
+		}},
+		{"shutdown complete with a reflected tag", []send{
+			{port, port + 1, 0, sctpInitChunk(SCTP_INIT, 0xa3), 0},
+			{port + 1, port, 0xa3, sctpInitChunk(SCTP_INIT_ACK, 0xb3), 0},
+			first(port+1, port, 0xa3, 10),
+			first(port, port+1, 0xb3, 20),
+			{port, port + 1, 0xa3, sctpCloseChunk(SCTP_SHUTDOWN_COMPLETE, SCTP_FLAG_TAG_REFLECTED), 0},
+			last(port+1, port, 0xa3, 11, 0),
+			last(port, port+1, 0xb3, 21, 0),
+		}},
+		{"close without INIT ACK keeps the other direction", []send{
+			first(port+1, port, 0xa4, 10),
+			first(port, port+1, 0xb4, 20),
+			{port, port + 1, 0xb4, sctpCloseChunk(SCTP_ABORT, 0), 0},
+			last(port+1, port, 0xa4, 11, 1),
+			last(port, port+1, 0xb4, 21, 0),
+		}},
+	}
+	for _, tt := range tests {
+		for _, restore := range []bool{false, true} {
+			name := tt.name
+			if restore {
+				name += " through snapshots"
+			}
+			t.Run(name, func(t *testing.T) {
+				a := NewSctpAssembler()
+				for i, s := range tt.sends {
+					msgs := a.Add(sctpPacket(t, s.srcPort, s.destPort, s.vtag, s.chunk))
+					if len(msgs) != s.messages {
+						t.Errorf("packet %d: %d messages, want %d", i, len(msgs), s.messages)
+					}
+					if restore {
+						data, err := a.GobEncode()
+						if err != nil {
+							t.Fatal(err)
+						}
+						a = &SctpAssembler{}
+						if err := a.GobDecode(data); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
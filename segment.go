@@ -0,0 +1,65 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Segment describes one of the files of a stream of pcap files
+// concatenated back to back, as produced by cat a.pcap b.pcap.
+type Segment struct {
+	Header      FileHeader // file header, as stored
+	Info        CaptureInfo
+	FirstPacket int // number of the first packet record of the segment, from 1
+}
+
+// segmentMagic returns the byte order of a file header starting with b.
+func segmentMagic(b []byte) (magic uint32, flip bool, ok bool) {
+	switch m := binary.LittleEndian.Uint32(b); m {
+	case TCPDUMP_MAGIC, NSEC_TCPDUMP_MAGIC:
+		return m, false, true
+	case swapUint32(TCPDUMP_MAGIC), swapUint32(NSEC_TCPDUMP_MAGIC):
+		return swapUint32(m), true, true
+	}
+	return 0, false, false
+}
+
+// segment checks whether the record header d is the start of the file
+// header of a concatenated file. If so, it reads the rest of the header,
+// switches to the new byte order and resolution, and returns true.
+func (r *Reader) segment(d []byte) bool {
+	magic, flip, ok := segmentMagic(d)
+	if !ok || asUint16(d[4:6], flip) != 2 {
+		return false
+	}
+	rest := make([]byte, 8)
+	if r.err = r.read(rest); r.err != nil {
+		return false
+	}
+	header := FileHeader{
+		MagicNumber:  0xa1b23c4d,
+		VersionMajor: asUint16(d[4:6], flip),
+		VersionMinor: asUint16(d[6:8], flip),
+		TimeZone:     int32(asUint32(d[8:12], flip)),
+		SigFigs:      asUint32(d[12:16], flip),
+		SnapLen:      asUint32(rest[0:4], flip),
+		LinkType:     asUint32(rest[4:8], flip),
+	}
+	info := newCaptureInfo(magic, flip, header)
+	info.Size, info.EstimatedPackets = r.Info.Size, r.Info.EstimatedPackets
+	r.flip = flip
+	r.Header = header
+	r.Info = info
+	r.Segments = append(r.Segments, Segment{
+		Header:      info.Header,
+		Info:        info,
+		FirstPacket: r.packets + 1,
+	})
+	r.Events.Publish(&Event{
+		Kind:    EVENT_SEGMENT,
+		Source:  "reader",
+		Packet:  r.packets + 1,
+		Message: fmt.Sprintf("file header %s linktype %d snaplen %d", info.Variant, header.LinkType, header.SnapLen),
+	})
+	return true
+}
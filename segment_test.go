@@ -0,0 +1,155 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+type segmentFile struct {
+	order      binary.ByteOrder
+	resolution time.Duration
+	linkType   uint32
+	times      []time.Time
+}
+
+// bytes writes the file, with one packet per time whose data is its index
+// within the file.
+func (f segmentFile) bytes(t *testing.T) []byte {
+	t.Helper()
+	magic := uint32(NSEC_TCPDUMP_MAGIC)
+	if f.resolution == time.Microsecond {
+		magic = TCPDUMP_MAGIC
+	}
+	info := CaptureInfo{
+		Header:     FileHeader{MagicNumber: magic, VersionMajor: 2, VersionMinor: 4, SnapLen: 65535, LinkType: f.linkType},
+		ByteOrder:  f.order,
+		Resolution: f.resolution,
+	}
+	var buf bytes.Buffer
+	w, err := NewPreservingWriter(&buf, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ts := range f.times {
+		data := []byte{byte(i), 0xaa, 0xbb, 0xcc}
+		if err := w.Write(&Packet{Time: ts, Caplen: uint32(len(data)), Len: 60, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// onlyReader hides the Seek method of a reader.
+type onlyReader struct{ io.Reader }
+
+func TestReaderSegments(t *testing.T) {
+	t0 := time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.UTC)
+	le, be := binary.LittleEndian, binary.BigEndian
+	us, ns := time.Microsecond, time.Nanosecond
+	tests := []struct {
+		name  string
+		files []segmentFile
+	}{
+		{"single file", []segmentFile{
+			{le, us, 1, []time.Time{t0, t0.Add(time.Second)}},
+		}},
+		{"same format", []segmentFile{
+			{le, us, 1, []time.Time{t0}},
+			{le, us, 1, []time.Time{t0.Add(time.Second), t0.Add(2 * time.Second)}},
+		}},
+		{"byte order", []segmentFile{
+			{le, us, 1, []time.Time{t0, t0.Add(time.Millisecond)}},
+			{be, us, 1, []time.Time{t0.Add(time.Second)}},
+			{le, us, 1, []time.Time{t0.Add(2 * time.Second)}},
+		}},
+		{"resolution", []segmentFile{
+			{le, ns, 1, []time.Time{t0}},
+			{le, us, 1, []time.Time{t0.Add(time.Second)}},
+			{le, ns, 1, []time.Time{t0.Add(2 * time.Second)}},
+		}},
+		{"byte order and resolution", []segmentFile{
+			{be, ns, 1, []time.Time{t0, t0.Add(time.Nanosecond)}},
+			{le, us, 101, []time.Time{t0.Add(time.Second)}},
+			{be, us, 1, []time.Time{t0.Add(2 * time.Second)}},
+			{le, ns, 1, []time.Time{t0.Add(3 * time.Second), t0.Add(4 * time.Second)}},
+		}},
+		{"empty file in between", []segmentFile{
+			{le, us, 1, []time.Time{t0}},
+			{be, ns, 1, nil},
+			{le, ns, 1, []time.Time{t0.Add(time.Second)}},
+		}},
+		{"seconds look like a magic number", []segmentFile{
+			{le, us, 1, []time.Time{time.Unix(TCPDUMP_MAGIC, 0)}},
+			{be, us, 1, []time.Time{time.Unix(TCPDUMP_MAGIC, 0)}},
+		}},
+	}
+	for _, tt := range tests {
+		var data []byte
+		var want []time.Time
+		var index []byte // index of each packet within its file
+		var first []int
+		for _, f := range tt.files {
+			data = append(data, f.bytes(t)...)
+			first = append(first, len(want)+1)
+			for i, ts := range f.times {
+				want = append(want, ts.Truncate(f.resolution))
+				index = append(index, byte(i))
+			}
+		}
+		for _, src := range []struct {
+			name string
+			r    io.Reader
+		}{
+			{"seeker", bytes.NewReader(data)},
+			{"stream", onlyReader{bytes.NewReader(data)}},
+		} {
+			t.Run(tt.name+"/"+src.name, func(t *testing.T) {
+				r, err := NewReader(src.r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.Events = NewEventBus()
+				events := 0
+				r.Events.Subscribe(func(*Event) { events++ }, EVENT_SEGMENT)
+				var got []time.Time
+				for p := r.Next(); p != nil; p = r.Next() {
+					if n := len(got); n < len(index) && p.Data[0] != index[n] {
+						t.Errorf("packet %d: data %x", n+1, p.Data)
+					}
+					got = append(got, p.Time)
+				}
+				if r.Err() != nil {
+					t.Fatal(r.Err())
+				}
+				if len(got) != len(want) {
+					t.Fatalf("read %d packets, want %d", len(got), len(want))
+				}
+				for i := range want {
+					if !got[i].Equal(want[i]) {
+						t.Errorf("packet %d: time %v, want %v", i+1, got[i], want[i])
+					}
+				}
+				if len(r.Segments) != len(tt.files) || events != len(tt.files)-1 {
+					t.Fatalf("%d segments and %d events, want %d", len(r.Segments), events, len(tt.files))
+				}
+				for i, s := range r.Segments {
+					f := tt.files[i]
+					if s.FirstPacket != first[i] || s.Info.ByteOrder != f.order ||
+						s.Info.Resolution != f.resolution || s.Header.LinkType != f.linkType {
+						t.Errorf("segment %d: first %d order %v resolution %v linktype %d, want %d %v %v %d",
+							i, s.FirstPacket, s.Info.ByteOrder, s.Info.Resolution, s.Header.LinkType,
+							first[i], f.order, f.resolution, f.linkType)
+					}
+				}
+				last := tt.files[len(tt.files)-1]
+				if r.Info.ByteOrder != last.order || r.Info.Resolution != last.resolution {
+					t.Errorf("info %v %v, want the last file's %v %v",
+						r.Info.ByteOrder, r.Info.Resolution, last.order, last.resolution)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// Snapshotter is analyzer state with unexported parts that serializes
+// itself with encoding/gob. GobDecode must replace all of the state that
+// GobEncode saved. TcpThroughput and SctpAssembler are Snapshotters;
+// QosStats and other analyzers with only exported fields are encoded by
+// gob as they are.
+type Snapshotter interface {
+	gob.GobEncoder
+	gob.GobDecoder
+}
+
+const snapshotMagic = "pcap-snapshot/1"
+
+// SaveSnapshot atomically replaces the file at path with the state of the
+// analyzers, together with the number of packets of the capture they have
+// seen. The file is written next to path and renamed, so a crash leaves
+// either the previous snapshot or the new one.
+func SaveSnapshot(path string, packets int, analyzers ...interface{}) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(snapshotMagic); err != nil {
+		return err
+	}
+	if err := enc.Encode(packets); err != nil {
+		return err
+	}
+	for _, a := range analyzers {
+		if err := enc.Encode(a); err != nil {
+			return fmt.Errorf("pcap: snapshot %T: %v", a, err)
+		}
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// LoadSnapshot restores analyzers saved by SaveSnapshot, which must be
+// passed as pointers in the same order, and returns the number of packets
+// they had seen. Processing resumes by skipping that many packets:
+//
+//	n, err := pcap.LoadSnapshot(path, tcp, &qos)
+//	...
+//	_, err = r.Skip(n)
+//
+// Analyzers that are not Snapshotters are zeroed first: gob never saves
+// zero values and leaves the fields it does not find unchanged.
+func LoadSnapshot(path string, analyzers ...interface{}) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	var magic string
+	if err := dec.Decode(&magic); err != nil || magic != snapshotMagic {
+		return 0, fmt.Errorf("pcap: %s is not a snapshot", path)
+	}
+	var packets int
+	if err := dec.Decode(&packets); err != nil {
+		return 0, err
+	}
+	for _, a := range analyzers {
+		if _, ok := a.(Snapshotter); !ok {
+			if v := reflect.ValueOf(a); v.Kind() == reflect.Ptr && !v.IsNil() {
+				v.Elem().Set(reflect.Zero(v.Elem().Type()))
+			}
+		}
+		if err := dec.Decode(a); err != nil {
+			return 0, fmt.Errorf("pcap: snapshot %T: %v", a, err)
+		}
+	}
+	return packets, nil
+}
+
+// Checkpoint periodically saves the state of analyzers while a capture is
+// processed, and restores it after a restart.
+type Checkpoint struct {
+	Path      string
+	Interval  int           // packets between snapshots
+	Analyzers []interface{} // pointers to the analyzers' state
+	Packets   int           // packets processed, including restored ones
+}
+
+// Restore loads the snapshot at Path, if there is one, and skips the
+// packets it covers in r.
+func (c *Checkpoint) Restore(r *Reader) error {
+	n, err := LoadSnapshot(c.Path, c.Analyzers...)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := r.Skip(n); err != nil {
+		return err
+	}
+	c.Packets = n
+	return nil
+}
+
+// Packet counts a processed packet and saves a snapshot every Interval
+// packets. It must be called once the analyzers have seen the packet.
+func (c *Checkpoint) Packet() error {
+	c.Packets++
+	if c.Interval > 0 && c.Packets%c.Interval == 0 {
+		return c.Save()
+	}
+	return nil
+}
+
+// Save writes a snapshot now, for example at the end of the capture.
+func (c *Checkpoint) Save() error {
+	return SaveSnapshot(c.Path, c.Packets, c.Analyzers...)
+}
+
+// GobEncode implements Snapshotter.
+func (t *TcpThroughput) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	flows := make([]*TcpFlow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	err := gob.NewEncoder(&buf).Encode(flows)
+	return buf.Bytes(), err
+}
+
+// GobDecode implements Snapshotter.
+func (t *TcpThroughput) GobDecode(data []byte) error {
+	var flows []*TcpFlow
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&flows); err != nil {
+		return err
+	}
+	t.flows = make(map[TcpFlowKey]*TcpFlow, len(flows))
+	for _, f := range flows {
+		t.flows[f.Key] = f
+	}
+	return nil
+}
+
+// sctpSnapshot mirrors the state of an SctpAssembler with exported fields.
+type sctpSnapshot struct {
+	MaxFragments int
+	Dropped      uint64
+	Assocs       []sctpAssocSnapshot
+	Peers        []sctpPeerSnapshot
+}
+
+type sctpKeySnapshot struct {
+	SrcPort  uint16
+	DestPort uint16
+	Vtag     uint32
+}
+
+// sctpPeerSnapshot pairs a direction with the other one of its
+// association.
+type sctpPeerSnapshot struct {
+	Key, Peer sctpKeySnapshot
+}
+
+type sctpAssocSnapshot struct {
+	SrcPort  uint16
+	DestPort uint16
+	Vtag     uint32
+	High     uint32
+	Synced   bool
+	Done     []uint32
+	Frags    []sctpFragmentSnapshot
+}
+
+type sctpFragmentSnapshot struct {
+	Tsn       uint32
+	Flags     uint8
+	StreamId  uint16
+	StreamSeq uint16
+	Ppid      uint32
+	Data      []byte
+}
+
+// GobEncode implements Snapshotter.
+func (a *SctpAssembler) GobEncode() ([]byte, error) {
+	s := sctpSnapshot{MaxFragments: a.MaxFragments, Dropped: a.Dropped}
+	for k, as := range a.assocs {
+		snap := sctpAssocSnapshot{
+			SrcPort:  k.srcPort,
+			DestPort: k.destPort,
+			Vtag:     k.vtag,
+			High:     as.high,
+			Synced:   as.synced,
+		}
+		for t := range as.done {
+			snap.Done = append(snap.Done, t)
+		}
+		for t, f := range as.frags {
+			snap.Frags = append(snap.Frags, sctpFragmentSnapshot{
+				Tsn:       t,
+				Flags:     f.flags,
+				StreamId:  f.streamId,
+				StreamSeq: f.streamSeq,
+				Ppid:      f.ppid,
+				Data:      f.data,
+			})
+		}
+		s.Assocs = append(s.Assocs, snap)
+	}
+	for k, peer := range a.peers {
+		s.Peers = append(s.Peers, sctpPeerSnapshot{
+			Key:  sctpKeySnapshot{k.srcPort, k.destPort, k.vtag},
+			Peer: sctpKeySnapshot{peer.srcPort, peer.destPort, peer.vtag},
+		})
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&s)
+	return buf.Bytes(), err
+}
+
+// GobDecode implements Snapshotter.
+func (a *SctpAssembler) GobDecode(data []byte) error {
+	var s sctpSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	a.MaxFragments, a.Dropped = s.MaxFragments, s.Dropped
+	a.assocs = make(map[sctpAssocKey]*sctpAssociation, len(s.Assocs))
+	for _, snap := range s.Assocs {
+		as := &sctpAssociation{
+			frags:  make(map[uint32]*sctpFragment, len(snap.Frags)),
+			done:   make(map[uint32]bool, len(snap.Done)),
+			high:   snap.High,
+			synced: snap.Synced,
+		}
+		for _, t := range snap.Done {
+			as.done[t] = true
+		}
+		for _, f := range snap.Frags {
+			as.frags[f.Tsn] = &sctpFragment{
+				flags:     f.Flags,
+				streamId:  f.StreamId,
+				streamSeq: f.StreamSeq,
+				ppid:      f.Ppid,
+				data:      f.Data,
+			}
+		}
+		a.assocs[sctpAssocKey{snap.SrcPort, snap.DestPort, snap.Vtag}] = as
+	}
+	a.peers = make(map[sctpAssocKey]sctpAssocKey, len(s.Peers))
+	for _, p := range s.Peers {
+		a.peers[sctpAssocKey{p.Key.SrcPort, p.Key.DestPort, p.Key.Vtag}] =
+			sctpAssocKey{p.Peer.SrcPort, p.Peer.DestPort, p.Peer.Vtag}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package pcap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type countsAnalyzer struct {
+	Total  int
+	Counts map[string]int
+}
+
+func TestLoadSnapshotReplacesState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	tests := []struct {
+		name        string
+		saved, live interface{}
+	}{
+		{"zero counts", &QosStats{}, &QosStats{Packets: 3, Bytes: 180, EcnPackets: [4]uint64{3}}},
+		{"fewer counts", &QosStats{Packets: 1, Bytes: 60}, &QosStats{Packets: 3, Bytes: 180, DscpPackets: [64]uint64{46: 3}}},
+		{"map entries", &countsAnalyzer{Total: 1, Counts: map[string]int{"a": 1}}, &countsAnalyzer{Total: 2, Counts: map[string]int{"a": 1, "b": 1}}},
+		{"empty map", &countsAnalyzer{}, &countsAnalyzer{Total: 2, Counts: map[string]int{"a": 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SaveSnapshot(path, 7, tt.saved); err != nil {
+				t.Fatal(err)
+			}
+			n, err := LoadSnapshot(path, tt.live)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 7 {
+				t.Errorf("%d packets, want 7", n)
+			}
+			if !reflect.DeepEqual(tt.live, tt.saved) {
+				t.Errorf("restored %+v, want %+v", tt.live, tt.saved)
+			}
+		})
+	}
+
+	t.Run("snapshotter keeps its events", func(t *testing.T) {
+		if err := SaveSnapshot(path, 1, NewTcpThroughput()); err != nil {
+			t.Fatal(err)
+		}
+		tcp := NewTcpThroughput()
+		tcp.Events = NewEventBus()
+		if _, err := LoadSnapshot(path, tcp); err != nil {
+			t.Fatal(err)
+		}
+		if tcp.Events == nil {
+			t.Error("restoring dropped the event bus")
+		}
+	})
+}
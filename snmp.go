@@ -0,0 +1,381 @@
+package pcap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// UDP ports of SNMP agents and trap receivers.
+const (
+	SNMP_PORT      = 161
+	SNMP_TRAP_PORT = 162
+)
+
+// SNMP versions as encoded in messages.
+const (
+	SNMP_V1  = 0
+	SNMP_V2C = 1
+	SNMP_V3  = 3
+)
+
+// SNMP PDU types, RFC 1157 and RFC 3416.
+const (
+	SNMP_GET_REQUEST      = 0xA0
+	SNMP_GET_NEXT_REQUEST = 0xA1
+	SNMP_RESPONSE         = 0xA2
+	SNMP_SET_REQUEST      = 0xA3
+	SNMP_TRAP_V1          = 0xA4
+	SNMP_GET_BULK_REQUEST = 0xA5
+	SNMP_INFORM_REQUEST   = 0xA6
+	SNMP_TRAP_V2          = 0xA7
+	SNMP_REPORT           = 0xA8
+)
+
+// BER and SNMP value types.
+const (
+	BER_INTEGER      = 0x02
+	BER_OCTET_STRING = 0x04
+	BER_NULL         = 0x05
+	BER_OID          = 0x06
+	BER_SEQUENCE     = 0x30
+
+	SNMP_IPADDRESS        = 0x40
+	SNMP_COUNTER32        = 0x41
+	SNMP_GAUGE32          = 0x42
+	SNMP_TIMETICKS        = 0x43
+	SNMP_OPAQUE           = 0x44
+	SNMP_COUNTER64        = 0x46
+	SNMP_NO_SUCH_OBJECT   = 0x80
+	SNMP_NO_SUCH_INSTANCE = 0x81
+	SNMP_END_OF_MIB_VIEW  = 0x82
+)
+
+var snmpPduNames = map[uint8]string{
+	SNMP_GET_REQUEST: "GetRequest", SNMP_GET_NEXT_REQUEST: "GetNextRequest",
+	SNMP_RESPONSE: "Response", SNMP_SET_REQUEST: "SetRequest",
+	SNMP_TRAP_V1: "Trap", SNMP_GET_BULK_REQUEST: "GetBulkRequest",
+	SNMP_INFORM_REQUEST: "InformRequest", SNMP_TRAP_V2: "SNMPv2-Trap",
+	SNMP_REPORT: "Report",
+}
+
+var errBer = errors.New("pcap: malformed BER encoding")
+
+// SnmpMessage is a decoded SNMP message. For version 3, the scoped PDU is
+// only decoded when it is not encrypted.
+type SnmpMessage struct {
+	Version   int    // see SNMP_V*
+	Community string // versions 1 and 2c
+
+	MsgId       int    // version 3
+	MsgFlags    uint8  // version 3; bit 1 set when the PDU is encrypted
+	ContextName string // version 3
+
+	PduType     uint8 // see SNMP_*, zero if the PDU is encrypted
+	RequestId   int32
+	ErrorStatus int // non-repeaters in GetBulkRequest
+	ErrorIndex  int // max-repetitions in GetBulkRequest
+
+	// Version 1 trap fields.
+	Enterprise   string
+	AgentAddr    net.IP
+	GenericTrap  int
+	SpecificTrap int
+	Timestamp    uint32 // time ticks
+
+	Varbinds []SnmpVarbind
+}
+
+// SnmpVarbind is one variable binding. Value is an int64 for integers, a
+// uint64 for counters, gauges and time ticks, a string for OIDs, a net.IP
+// for addresses, a []byte for strings and opaque values, and nil otherwise.
+type SnmpVarbind struct {
+	Oid   string
+	Type  uint8
+	Value interface{}
+}
+
+// Encrypted reports whether a version 3 PDU is encrypted.
+func (m *SnmpMessage) Encrypted() bool { return m.Version == SNMP_V3 && m.MsgFlags&0x02 != 0 }
+
+func (m *SnmpMessage) String() string {
+	version := "v1"
+	switch m.Version {
+	case SNMP_V2C:
+		version = "v2c"
+	case SNMP_V3:
+		version = "v3"
+	}
+	if m.Encrypted() {
+		return fmt.Sprintf("SNMP %s encrypted", version)
+	}
+	name, ok := snmpPduNames[m.PduType]
+	if !ok {
+		name = fmt.Sprintf("pdu=%#x", m.PduType)
+	}
+	oids := make([]string, len(m.Varbinds))
+	for i, vb := range m.Varbinds {
+		oids[i] = vb.Oid
+	}
+	return fmt.Sprintf("SNMP %s %s ID=%d ERR=%d/%d OIDS=%v",
+		version, name, m.RequestId, m.ErrorStatus, m.ErrorIndex, oids)
+}
+
+// DecodeSnmp decodes an SNMP message, such as the payload of a UDP packet
+// to or from SNMP_PORT or SNMP_TRAP_PORT.
+func DecodeSnmp(data []byte) (*SnmpMessage, error) {
+	tag, msg, _, err := berRead(data)
+	if err != nil {
+		return nil, err
+	}
+	if tag != BER_SEQUENCE {
+		return nil, errBer
+	}
+	m := &SnmpMessage{}
+	version, msg, err := berReadInt(msg)
+	if err != nil {
+		return nil, err
+	}
+	m.Version = int(version)
+
+	if m.Version == SNMP_V3 {
+		if err := m.decodeV3(msg); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	tag, community, msg, err := berRead(msg)
+	if err != nil {
+		return nil, err
+	}
+	if tag != BER_OCTET_STRING {
+		return nil, errBer
+	}
+	m.Community = string(community)
+	if err := m.decodePdu(msg); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *SnmpMessage) decodeV3(msg []byte) error {
+	tag, global, msg, err := berRead(msg)
+	if err != nil || tag != BER_SEQUENCE {
+		return errBer
+	}
+	id, global, err := berReadInt(global)
+	if err != nil {
+		return err
+	}
+	m.MsgId = int(id)
+	if _, global, err = berReadInt(global); err != nil { // msgMaxSize
+		return err
+	}
+	tag, flags, _, err := berRead(global)
+	if err != nil || tag != BER_OCTET_STRING || len(flags) != 1 {
+		return errBer
+	}
+	m.MsgFlags = flags[0]
+	if _, _, msg, err = berRead(msg); err != nil { // security parameters
+		return err
+	}
+	if m.Encrypted() {
+		return nil
+	}
+	tag, scoped, _, err := berRead(msg)
+	if err != nil || tag != BER_SEQUENCE {
+		return errBer
+	}
+	if _, _, scoped, err = berRead(scoped); err != nil { // context engine id
+		return err
+	}
+	tag, name, scoped, err := berRead(scoped)
+	if err != nil || tag != BER_OCTET_STRING {
+		return errBer
+	}
+	m.ContextName = string(name)
+	return m.decodePdu(scoped)
+}
+
+func (m *SnmpMessage) decodePdu(data []byte) error {
+	tag, pdu, _, err := berRead(data)
+	if err != nil {
+		return err
+	}
+	m.PduType = tag
+	if tag == SNMP_TRAP_V1 {
+		return m.decodeTrapV1(pdu)
+	}
+	id, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return err
+	}
+	m.RequestId = int32(id)
+	status, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return err
+	}
+	m.ErrorStatus = int(status)
+	index, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return err
+	}
+	m.ErrorIndex = int(index)
+	return m.decodeVarbinds(pdu)
+}
+
+func (m *SnmpMessage) decodeTrapV1(pdu []byte) error {
+	tag, oid, pdu, err := berRead(pdu)
+	if err != nil || tag != BER_OID {
+		return errBer
+	}
+	if m.Enterprise, err = berOid(oid); err != nil {
+		return err
+	}
+	tag, addr, pdu, err := berRead(pdu)
+	if err != nil || tag != SNMP_IPADDRESS || len(addr) != 4 {
+		return errBer
+	}
+	m.AgentAddr = net.IP(addr)
+	generic, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return err
+	}
+	specific, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return err
+	}
+	m.GenericTrap, m.SpecificTrap = int(generic), int(specific)
+	tag, ticks, pdu, err := berRead(pdu)
+	if err != nil || tag != SNMP_TIMETICKS {
+		return errBer
+	}
+	m.Timestamp = uint32(berUint(ticks))
+	return m.decodeVarbinds(pdu)
+}
+
+func (m *SnmpMessage) decodeVarbinds(data []byte) error {
+	tag, list, _, err := berRead(data)
+	if err != nil || tag != BER_SEQUENCE {
+		return errBer
+	}
+	for len(list) > 0 {
+		var vb, oid, value []byte
+		var v SnmpVarbind
+		if tag, vb, list, err = berRead(list); err != nil || tag != BER_SEQUENCE {
+			return errBer
+		}
+		if tag, oid, vb, err = berRead(vb); err != nil || tag != BER_OID {
+			return errBer
+		}
+		if v.Oid, err = berOid(oid); err != nil {
+			return err
+		}
+		if tag, value, _, err = berRead(vb); err != nil {
+			return err
+		}
+		v.Type = tag
+		switch tag {
+		case BER_INTEGER:
+			v.Value = berInt(value)
+		case BER_OCTET_STRING, SNMP_OPAQUE:
+			v.Value = value
+		case BER_OID:
+			if v.Value, err = berOid(value); err != nil {
+				return err
+			}
+		case SNMP_IPADDRESS:
+			v.Value = net.IP(value)
+		case SNMP_COUNTER32, SNMP_GAUGE32, SNMP_TIMETICKS, SNMP_COUNTER64:
+			v.Value = berUint(value)
+		}
+		m.Varbinds = append(m.Varbinds, v)
+	}
+	return nil
+}
+
+// berRead splits the first TLV off data.
+func berRead(data []byte) (tag uint8, value, rest []byte, err error) {
+	if len(data) < 2 || data[0]&0x1F == 0x1F {
+		return 0, nil, nil, errBer
+	}
+	tag = data[0]
+	n := int(data[1])
+	data = data[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7F
+		if size == 0 || size > 4 || len(data) < size {
+			return 0, nil, nil, errBer
+		}
+		n = 0
+		for _, c := range data[:size] {
+			n = n<<8 | int(c)
+		}
+		data = data[size:]
+	}
+	if n < 0 || n > len(data) {
+		return 0, nil, nil, errBer
+	}
+	return tag, data[:n], data[n:], nil
+}
+
+func berReadInt(data []byte) (int64, []byte, error) {
+	tag, value, rest, err := berRead(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag != BER_INTEGER {
+		return 0, nil, errBer
+	}
+	return berInt(value), rest, nil
+}
+
+func berInt(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	v := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func berUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// berOid formats an object identifier in dotted notation.
+func berOid(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", errBer
+	}
+	var parts []string
+	var v uint64
+	for i, c := range b {
+		v = v<<7 | uint64(c&0x7F)
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return "", errBer
+			}
+			continue
+		}
+		if parts == nil {
+			first := v / 40
+			if first > 2 {
+				first = 2
+			}
+			parts = append(parts, strconv.FormatUint(first, 10), strconv.FormatUint(v-40*first, 10))
+		} else {
+			parts = append(parts, strconv.FormatUint(v, 10))
+		}
+		v = 0
+	}
+	return strings.Join(parts, "."), nil
+}
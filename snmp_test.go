@@ -0,0 +1,263 @@
+package pcap
+
+import (
+	"encoding/hex"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// ber encodes a TLV whose value is the concatenation of parts.
+func ber(tag uint8, parts ...[]byte) []byte {
+	var v []byte
+	for _, p := range parts {
+		v = append(v, p...)
+	}
+	n := len(v)
+	switch {
+	case n < 0x80:
+		return append([]byte{tag, byte(n)}, v...)
+	case n < 0x100:
+		return append([]byte{tag, 0x81, byte(n)}, v...)
+	default:
+		return append([]byte{tag, 0x82, byte(n >> 8), byte(n)}, v...)
+	}
+}
+
+func berI(v ...byte) []byte     { return ber(BER_INTEGER, v) }
+func berS(s string) []byte      { return ber(BER_OCTET_STRING, []byte(s)) }
+func berO(v ...byte) []byte     { return ber(BER_OID, v) }
+func berNull() []byte           { return ber(BER_NULL) }
+func berSeq(p ...[]byte) []byte { return ber(BER_SEQUENCE, p...) }
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var (
+	oidSysDescr  = []byte{0x2b, 6, 1, 2, 1, 1, 1, 0}                // 1.3.6.1.2.1.1.1.0
+	oidSysUpTime = []byte{0x2b, 6, 1, 2, 1, 1, 3, 0}                // 1.3.6.1.2.1.1.3.0
+	oidIfInOct   = []byte{0x2b, 6, 1, 2, 1, 2, 2, 1, 10, 1}         // 1.3.6.1.2.1.2.2.1.10.1
+	oidLarge     = []byte{0x2b, 6, 1, 4, 1, 0x83, 0xe8, 0x3f, 1, 2} // 1.3.6.1.4.1.62527.1.2
+)
+
+func TestDecodeSnmp(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want *SnmpMessage
+	}{
+		{
+			name: "v1 get request",
+			// Captured GetRequest for sysDescr.0.
+			data: mustHex(t, "302902010004067075626c6963a01c0204"+
+				"1234567802010002010030"+"0e300c06082b06010201010100"+"0500"),
+			want: &SnmpMessage{
+				Version:   SNMP_V1,
+				Community: "public",
+				PduType:   SNMP_GET_REQUEST,
+				RequestId: 0x12345678,
+				Varbinds:  []SnmpVarbind{{Oid: "1.3.6.1.2.1.1.1.0", Type: BER_NULL}},
+			},
+		},
+		{
+			name: "v2c response",
+			data: berSeq(berI(SNMP_V2C), berS("private"), ber(SNMP_RESPONSE,
+				berI(0xff, 0xfe), berI(0), berI(0), berSeq(
+					berSeq(berO(oidSysDescr...), berS("router")),
+					berSeq(berO(oidSysUpTime...), ber(SNMP_TIMETICKS, []byte{0x00, 0xff, 0xff, 0xff, 0xff})),
+					berSeq(berO(oidIfInOct...), ber(SNMP_COUNTER64, []byte{0, 0x80, 0, 0, 0, 0, 0, 0, 0})),
+					berSeq(berO(oidLarge...), ber(SNMP_IPADDRESS, []byte{192, 0, 2, 1})),
+					berSeq(berO(oidLarge...), berO(oidSysDescr...)),
+					berSeq(berO(oidLarge...), berI(0x80)),
+					berSeq(berO(oidLarge...), ber(SNMP_NO_SUCH_INSTANCE)),
+				))),
+			want: &SnmpMessage{
+				Version:   SNMP_V2C,
+				Community: "private",
+				PduType:   SNMP_RESPONSE,
+				RequestId: -2,
+				Varbinds: []SnmpVarbind{
+					{Oid: "1.3.6.1.2.1.1.1.0", Type: BER_OCTET_STRING, Value: []byte("router")},
+					{Oid: "1.3.6.1.2.1.1.3.0", Type: SNMP_TIMETICKS, Value: uint64(0xffffffff)},
+					{Oid: "1.3.6.1.2.1.2.2.1.10.1", Type: SNMP_COUNTER64, Value: uint64(1) << 63},
+					{Oid: "1.3.6.1.4.1.62527.1.2", Type: SNMP_IPADDRESS, Value: net.IP{192, 0, 2, 1}},
+					{Oid: "1.3.6.1.4.1.62527.1.2", Type: BER_OID, Value: "1.3.6.1.2.1.1.1.0"},
+					{Oid: "1.3.6.1.4.1.62527.1.2", Type: BER_INTEGER, Value: int64(-128)},
+					{Oid: "1.3.6.1.4.1.62527.1.2", Type: SNMP_NO_SUCH_INSTANCE},
+				},
+			},
+		},
+		{
+			name: "v2c get bulk",
+			data: berSeq(berI(SNMP_V2C), berS("public"), ber(SNMP_GET_BULK_REQUEST,
+				berI(7), berI(1), berI(10), berSeq(
+					berSeq(berO(oidSysUpTime...), berNull()),
+					berSeq(berO(oidIfInOct...), berNull()),
+				))),
+			want: &SnmpMessage{
+				Version:     SNMP_V2C,
+				Community:   "public",
+				PduType:     SNMP_GET_BULK_REQUEST,
+				RequestId:   7,
+				ErrorStatus: 1,
+				ErrorIndex:  10,
+				Varbinds: []SnmpVarbind{
+					{Oid: "1.3.6.1.2.1.1.3.0", Type: BER_NULL},
+					{Oid: "1.3.6.1.2.1.2.2.1.10.1", Type: BER_NULL},
+				},
+			},
+		},
+		{
+			name: "v1 trap",
+			data: berSeq(berI(SNMP_V1), berS("public"), ber(SNMP_TRAP_V1,
+				berO(oidLarge...), ber(SNMP_IPADDRESS, []byte{10, 0, 0, 1}),
+				berI(6), berI(42), ber(SNMP_TIMETICKS, []byte{0x01, 0x00}),
+				berSeq(berSeq(berO(oidIfInOct...), ber(SNMP_COUNTER32, []byte{0x00, 0x80}))),
+			)),
+			want: &SnmpMessage{
+				Version:      SNMP_V1,
+				Community:    "public",
+				PduType:      SNMP_TRAP_V1,
+				Enterprise:   "1.3.6.1.4.1.62527.1.2",
+				AgentAddr:    net.IP{10, 0, 0, 1},
+				GenericTrap:  6,
+				SpecificTrap: 42,
+				Timestamp:    256,
+				Varbinds: []SnmpVarbind{
+					{Oid: "1.3.6.1.2.1.2.2.1.10.1", Type: SNMP_COUNTER32, Value: uint64(128)},
+				},
+			},
+		},
+		{
+			name: "v3 plaintext report",
+			data: berSeq(berI(SNMP_V3),
+				berSeq(berI(0x01, 0x00), berI(0x05, 0xdc), berS("\x04"), berI(3)),
+				berS(string(berSeq(berS(""), berI(0), berI(0), berS(""), berS(""), berS("")))),
+				berSeq(berS("\x80\x00\x1f\x88"), berS("ctx"), ber(SNMP_REPORT,
+					berI(9), berI(0), berI(0),
+					berSeq(berSeq(berO(oidSysDescr...), ber(SNMP_COUNTER32, []byte{1}))),
+				)),
+			),
+			want: &SnmpMessage{
+				Version:     SNMP_V3,
+				MsgId:       256,
+				MsgFlags:    0x04,
+				ContextName: "ctx",
+				PduType:     SNMP_REPORT,
+				RequestId:   9,
+				Varbinds: []SnmpVarbind{
+					{Oid: "1.3.6.1.2.1.1.1.0", Type: SNMP_COUNTER32, Value: uint64(1)},
+				},
+			},
+		},
+		{
+			name: "v3 encrypted",
+			data: berSeq(berI(SNMP_V3),
+				berSeq(berI(0x2a), berI(0x05, 0xdc), berS("\x07"), berI(3)),
+				berS("usm parameters"),
+				berS("\x9b\x17\x00\x42 ciphertext"),
+			),
+			want: &SnmpMessage{
+				Version:  SNMP_V3,
+				MsgId:    42,
+				MsgFlags: 0x07,
+			},
+		},
+		{
+			name: "long form length",
+			data: berSeq(berI(SNMP_V2C), berS(string(make([]byte, 200))), ber(SNMP_GET_REQUEST,
+				berI(1), berI(0), berI(0), berSeq())),
+			want: &SnmpMessage{
+				Version:   SNMP_V2C,
+				Community: string(make([]byte, 200)),
+				PduType:   SNMP_GET_REQUEST,
+				RequestId: 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := DecodeSnmp(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSnmpMalformed(t *testing.T) {
+	get := berSeq(berI(SNMP_V2C), berS("public"), ber(SNMP_GET_REQUEST,
+		berI(1), berI(0), berI(0), berSeq(berSeq(berO(oidSysDescr...), berNull()))))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a sequence", ber(BER_OCTET_STRING, get[2:])},
+		{"truncated", get[:len(get)-3]},
+		{"length past end", append([]byte{BER_SEQUENCE, 0x84, 0x7f, 0xff, 0xff, 0xff}, get[2:]...)},
+		{"indefinite length", append([]byte{BER_SEQUENCE, 0x80}, get[2:]...)},
+		{"high tag number", berSeq(berI(SNMP_V2C), []byte{0x1f, 0x01, 0x00})},
+		{"community not a string", berSeq(berI(SNMP_V2C), berI(1))},
+		{"version not an integer", berSeq(berS("1"), berS("public"))},
+		{"empty oid", berSeq(berI(SNMP_V2C), berS("public"), ber(SNMP_GET_REQUEST,
+			berI(1), berI(0), berI(0), berSeq(berSeq(berO(), berNull()))))},
+		{"unterminated oid", berSeq(berI(SNMP_V2C), berS("public"), ber(SNMP_GET_REQUEST,
+			berI(1), berI(0), berI(0), berSeq(berSeq(berO(0x2b, 0x86), berNull()))))},
+		{"varbind not a sequence", berSeq(berI(SNMP_V2C), berS("public"), ber(SNMP_GET_REQUEST,
+			berI(1), berI(0), berI(0), berSeq(berO(oidSysDescr...))))},
+		{"trap address too short", berSeq(berI(SNMP_V1), berS("public"), ber(SNMP_TRAP_V1,
+			berO(oidLarge...), ber(SNMP_IPADDRESS, []byte{10, 0, 1}),
+			berI(6), berI(1), ber(SNMP_TIMETICKS, []byte{1}), berSeq()))},
+		{"v3 flags too long", berSeq(berI(SNMP_V3),
+			berSeq(berI(1), berI(0x05, 0xdc), berS("\x04\x00"), berI(3)),
+			berS(""), berSeq(berS(""), berS(""), ber(SNMP_GET_REQUEST))),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, err := DecodeSnmp(tt.data); err == nil {
+				t.Errorf("got %+v, want an error", m)
+			}
+		})
+	}
+}
+
+func TestSnmpString(t *testing.T) {
+	tests := []struct {
+		m    SnmpMessage
+		want string
+	}{
+		{
+			SnmpMessage{Version: SNMP_V1, PduType: SNMP_GET_REQUEST, RequestId: 5,
+				Varbinds: []SnmpVarbind{{Oid: "1.3.6.1.2.1.1.1.0"}}},
+			"SNMP v1 GetRequest ID=5 ERR=0/0 OIDS=[1.3.6.1.2.1.1.1.0]",
+		},
+		{
+			SnmpMessage{Version: SNMP_V2C, PduType: SNMP_RESPONSE, ErrorStatus: 2, ErrorIndex: 1},
+			"SNMP v2c Response ID=0 ERR=2/1 OIDS=[]",
+		},
+		{
+			SnmpMessage{Version: SNMP_V3, PduType: 0xbf},
+			"SNMP v3 pdu=0xbf ID=0 ERR=0/0 OIDS=[]",
+		},
+		{
+			SnmpMessage{Version: SNMP_V3, MsgFlags: 0x03},
+			"SNMP v3 encrypted",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Frames whose type field is at most ETHER_MAX_LEN carry an IEEE 802.3
+// length and an LLC header instead of an EtherType.
+const ETHER_MAX_LEN = 1500
+
+// LLC service access points and SNAP protocol ids.
+const (
+	LLC_SAP_STP  = 0x42
+	LLC_SAP_SNAP = 0xAA
+
+	SNAP_PID_PVST = 0x010B // Cisco PVST+, OUI 00:00:0c
+)
+
+// BPDU types, IEEE 802.1D and 802.1w.
+const (
+	BPDU_CONFIG = 0x00
+	BPDU_RST    = 0x02 // RSTP and MSTP
+	BPDU_TCN    = 0x80
+)
+
+// BPDU flags.
+const (
+	BPDU_FLAG_TC         = 0x01
+	BPDU_FLAG_PROPOSAL   = 0x02
+	BPDU_FLAG_LEARNING   = 0x10
+	BPDU_FLAG_FORWARDING = 0x20
+	BPDU_FLAG_AGREEMENT  = 0x40
+	BPDU_FLAG_TCA        = 0x80
+)
+
+// Llchdr is an IEEE 802.2 LLC header, optionally followed by SNAP.
+type Llchdr struct {
+	Dsap    uint8
+	Ssap    uint8
+	Control uint16 // one byte for unnumbered frames, two otherwise
+	Oui     uint32 // SNAP organization code
+	Pid     uint16 // SNAP protocol id
+}
+
+// Bpdu is a spanning tree bridge protocol data unit.
+type Bpdu struct {
+	ProtocolId   uint16
+	Version      uint8 // 0 STP, 2 RSTP, 3 MSTP
+	Type         uint8 // see BPDU_*
+	Flags        uint8 // see BPDU_FLAG_*
+	RootId       uint64
+	RootPathCost uint32
+	BridgeId     uint64
+	PortId       uint16
+	MessageAge   uint16 // in 1/256 seconds
+	MaxAge       uint16
+	HelloTime    uint16
+	ForwardDelay uint16
+}
+
+// Port roles encoded in the flags of RST BPDUs.
+const (
+	BPDU_ROLE_UNKNOWN    = 0
+	BPDU_ROLE_ALTERNATE  = 1 // alternate or backup
+	BPDU_ROLE_ROOT       = 2
+	BPDU_ROLE_DESIGNATED = 3
+)
+
+var bpduRoles = [4]string{"unknown", "alternate", "root", "designated"}
+
+// PortRole returns the port role of an RST BPDU, see BPDU_ROLE_*.
+func (b *Bpdu) PortRole() uint8 { return b.Flags >> 2 & 0x03 }
+
+// TopologyChange reports whether the topology change flag is set, or the
+// BPDU is a topology change notification.
+func (b *Bpdu) TopologyChange() bool {
+	return b.Type == BPDU_TCN || b.Flags&BPDU_FLAG_TC != 0
+}
+
+// RootPriority returns the priority part of the root bridge id.
+func (b *Bpdu) RootPriority() uint16 { return uint16(b.RootId >> 48) }
+
+// RootMac returns the MAC address part of the root bridge id.
+func (b *Bpdu) RootMac() uint64 { return b.RootId & 0xffffffffffff }
+
+// BridgePriority returns the priority part of the sending bridge id.
+func (b *Bpdu) BridgePriority() uint16 { return uint16(b.BridgeId >> 48) }
+
+// BridgeMac returns the MAC address part of the sending bridge id.
+func (b *Bpdu) BridgeMac() uint64 { return b.BridgeId & 0xffffffffffff }
+
+func bpduTime(v uint16) time.Duration {
+	return time.Duration(v) * time.Second / 256
+}
+
+func (b *Bpdu) String() string {
+	switch b.Type {
+	case BPDU_TCN:
+		return "STP TCN"
+	case BPDU_CONFIG, BPDU_RST:
+	default:
+		return fmt.Sprintf("STP type=%d", b.Type)
+	}
+	proto := "STP"
+	if b.Version >= 2 {
+		proto = "RSTP"
+	}
+	s := fmt.Sprintf("%s root=%d/%012x cost=%d bridge=%d/%012x port=%04x age=%v max=%v hello=%v fwd=%v",
+		proto, b.RootPriority(), b.RootMac(), b.RootPathCost,
+		b.BridgePriority(), b.BridgeMac(), b.PortId,
+		bpduTime(b.MessageAge), bpduTime(b.MaxAge),
+		bpduTime(b.HelloTime), bpduTime(b.ForwardDelay))
+	if b.Type == BPDU_RST {
+		s += " role=" + bpduRoles[b.PortRole()]
+	}
+	if b.TopologyChange() {
+		s += " tc"
+	}
+	return s
+}
+
+func (p *Packet) decodeLlc() {
+	pkt := p.Payload
+	if len(pkt) < 3 {
+		return
+	}
+	p.Llchdr.Dsap = pkt[0]
+	p.Llchdr.Ssap = pkt[1]
+	if pkt[2]&0x03 == 0x03 {
+		p.Llchdr.Control = uint16(pkt[2])
+		pkt = pkt[3:]
+	} else {
+		if len(pkt) < 4 {
+			return
+		}
+		p.Llchdr.Control = binary.BigEndian.Uint16(pkt[2:4])
+		pkt = pkt[4:]
+	}
+	if p.Llchdr.Dsap == LLC_SAP_SNAP && p.Llchdr.Ssap == LLC_SAP_SNAP {
+		if len(pkt) < 5 {
+			return
+		}
+		p.Llchdr.Oui = uint32(pkt[0])<<16 | uint32(pkt[1])<<8 | uint32(pkt[2])
+		p.Llchdr.Pid = binary.BigEndian.Uint16(pkt[3:5])
+		pkt = pkt[5:]
+		if p.Llchdr.Oui == 0x00000c && p.Llchdr.Pid == SNAP_PID_PVST {
+			p.decodeBpdu(pkt)
+		}
+		return
+	}
+	if p.Llchdr.Dsap == LLC_SAP_STP && p.Llchdr.Ssap == LLC_SAP_STP {
+		p.decodeBpdu(pkt)
+	}
+}
+
+func (p *Packet) decodeBpdu(pkt []byte) {
+	if len(pkt) < 4 || binary.BigEndian.Uint16(pkt[0:2]) != 0 {
+		return
+	}
+	b := &Bpdu{
+		ProtocolId: binary.BigEndian.Uint16(pkt[0:2]),
+		Version:    pkt[2],
+		Type:       pkt[3],
+	}
+	if b.Type != BPDU_TCN {
+		if len(pkt) < 35 {
+			return
+		}
+		b.Flags = pkt[4]
+		b.RootId = binary.BigEndian.Uint64(pkt[5:13])
+		b.RootPathCost = binary.BigEndian.Uint32(pkt[13:17])
+		b.BridgeId = binary.BigEndian.Uint64(pkt[17:25])
+		b.PortId = binary.BigEndian.Uint16(pkt[25:27])
+		b.MessageAge = binary.BigEndian.Uint16(pkt[27:29])
+		b.MaxAge = binary.BigEndian.Uint16(pkt[29:31])
+		b.HelloTime = binary.BigEndian.Uint16(pkt[31:33])
+		b.ForwardDelay = binary.BigEndian.Uint16(pkt[33:35])
+	}
+	p.Bpdu = b
+}
@@ -0,0 +1,65 @@
+package pcap
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ErrBrokenPipe is returned by a stream Writer once the reading end of its
+// pipe is gone, for example because wireshark or tcpdump exited.
+var ErrBrokenPipe = errors.New("pcap: broken pipe")
+
+// NewStreamWriter is like NewWriter, but for pipes: the header and every
+// packet are flushed as soon as they are written if writer has a Flush
+// method, and a closed pipe is reported as ErrBrokenPipe.
+func NewStreamWriter(writer io.Writer, header *FileHeader) (*Writer, error) {
+	w, err := NewWriter(writer, header)
+	if err != nil {
+		return nil, pipeErr(err)
+	}
+	w.stream = true
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewStdoutWriter creates a stream Writer on the unbuffered standard output,
+// in the format tcpdump -r - and wireshark -i - expect. It stops the runtime
+// from killing the process with SIGPIPE when the reader goes away, so that
+// Write returns ErrBrokenPipe and the caller can shut down cleanly.
+func NewStdoutWriter(header *FileHeader) (*Writer, error) {
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+	return NewStreamWriter(os.Stdout, header)
+}
+
+type flusher interface {
+	Flush() error
+}
+
+func (w *Writer) flush() error {
+	if !w.stream {
+		return nil
+	}
+	if f, ok := w.writer.(flusher); ok {
+		return pipeErr(f.Flush())
+	}
+	return nil
+}
+
+func (w *Writer) writeErr(err error) error {
+	if !w.stream {
+		return err
+	}
+	return pipeErr(err)
+}
+
+func pipeErr(err error) error {
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrClosedPipe) {
+		return ErrBrokenPipe
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package pcap
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UDP port of syslog, RFC 5426.
+const SYSLOG_PORT = 514
+
+var errSyslog = errors.New("pcap: malformed syslog message")
+
+var syslogSeverities = [8]string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// SyslogMessage is a decoded syslog message, in either the RFC 5424 format
+// or the older BSD format of RFC 3164. Fields missing from the message are
+// left empty.
+type SyslogMessage struct {
+	Facility uint8
+	Severity uint8
+	Version  int // 1 for RFC 5424, 0 for BSD syslog
+
+	// Timestamp is zero when absent. BSD timestamps carry no year or
+	// zone and are returned in year 0, UTC.
+	Timestamp time.Time
+	Hostname  string
+	AppName   string // the TAG of BSD messages
+	ProcId    string
+	MsgId     string
+
+	StructuredData string // RFC 5424 only, including the brackets
+	Message        string
+}
+
+func (m *SyslogMessage) String() string {
+	return fmt.Sprintf("SYSLOG %d.%s %s %s[%s]: %s",
+		m.Facility, syslogSeverities[m.Severity&7], m.Hostname, m.AppName, m.ProcId, m.Message)
+}
+
+// DecodeSyslog decodes a syslog message, such as the payload of a UDP
+// packet to SYSLOG_PORT.
+func DecodeSyslog(data []byte) (*SyslogMessage, error) {
+	s := strings.TrimRight(string(data), "\r\n\x00")
+	if len(s) < 3 || s[0] != '<' {
+		return nil, errSyslog
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return nil, errSyslog
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri > 191 {
+		return nil, errSyslog
+	}
+	m := &SyslogMessage{Facility: uint8(pri >> 3), Severity: uint8(pri & 7)}
+	s = s[end+1:]
+	if len(s) >= 2 && s[0] >= '1' && s[0] <= '9' && s[1] == ' ' {
+		m.Version = int(s[0] - '0')
+		if err := m.decode5424(s[2:]); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	m.decode3164(s)
+	return m, nil
+}
+
+// syslogField splits off the next space separated field, mapping the nil
+// value "-" to "".
+func syslogField(s string) (string, string) {
+	field, rest := s, ""
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		field, rest = s[:i], s[i+1:]
+	}
+	if field == "-" {
+		field = ""
+	}
+	return field, rest
+}
+
+func (m *SyslogMessage) decode5424(s string) error {
+	var stamp string
+	stamp, s = syslogField(s)
+	if stamp != "" {
+		t, err := time.Parse(time.RFC3339Nano, stamp)
+		if err != nil {
+			return errSyslog
+		}
+		m.Timestamp = t
+	}
+	m.Hostname, s = syslogField(s)
+	m.AppName, s = syslogField(s)
+	m.ProcId, s = syslogField(s)
+	m.MsgId, s = syslogField(s)
+	if strings.HasPrefix(s, "-") {
+		s = strings.TrimPrefix(s[1:], " ")
+	} else {
+		n := syslogSDLen(s)
+		if n < 0 {
+			return errSyslog
+		}
+		m.StructuredData = s[:n]
+		s = strings.TrimPrefix(s[n:], " ")
+	}
+	m.Message = strings.TrimPrefix(s, "\ufeff")
+	return nil
+}
+
+// syslogSDLen returns the length of the structured data elements at the
+// start of s, or -1 if they are malformed.
+func syslogSDLen(s string) int {
+	n := 0
+	for n < len(s) && s[n] == '[' {
+		quoted := false
+		i := n + 1
+		for ; i < len(s); i++ {
+			c := s[i]
+			if quoted && c == '\\' {
+				i++
+				continue
+			}
+			if c == '"' {
+				quoted = !quoted
+			}
+			if c == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(s) {
+			return -1
+		}
+		n = i + 1
+	}
+	if n == 0 {
+		return -1
+	}
+	return n
+}
+
+// decode3164 decodes "Mmm dd hh:mm:ss host tag[pid]: msg". Anything that
+// does not fit is kept as the message.
+func (m *SyslogMessage) decode3164(s string) {
+	m.Message = s
+	if len(s) < 16 || s[15] != ' ' {
+		return
+	}
+	t, err := time.Parse(time.Stamp, s[:15])
+	if err != nil {
+		return
+	}
+	m.Timestamp = t
+	s = s[16:]
+	m.Message = s
+	host, rest := syslogField(s)
+	if rest == "" {
+		return
+	}
+	m.Hostname = host
+	s = rest
+	m.Message = s
+	i := strings.IndexAny(s, "[: ")
+	if i <= 0 || i > 48 {
+		return
+	}
+	m.AppName = s[:i]
+	s = s[i:]
+	if s[0] == '[' {
+		j := strings.IndexByte(s, ']')
+		if j < 0 {
+			m.AppName = ""
+			return
+		}
+		m.ProcId = s[1:j]
+		s = s[j+1:]
+	}
+	s = strings.TrimPrefix(s, ":")
+	m.Message = strings.TrimPrefix(s, " ")
+}
@@ -0,0 +1,271 @@
+package pcap
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TcpFlowKey identifies one direction of a TCP connection.
+type TcpFlowKey struct {
+	SrcIp    [16]byte
+	DestIp   [16]byte
+	SrcPort  uint16
+	DestPort uint16
+}
+
+// Reverse returns the key of the opposite direction.
+func (k TcpFlowKey) Reverse() TcpFlowKey {
+	return TcpFlowKey{
+		SrcIp:    k.DestIp,
+		DestIp:   k.SrcIp,
+		SrcPort:  k.DestPort,
+		DestPort: k.SrcPort,
+	}
+}
+
+func (k TcpFlowKey) String() string {
+	return fmt.Sprintf("%s > %s",
+		net.JoinHostPort(net.IP(k.SrcIp[:]).String(), strconv.Itoa(int(k.SrcPort))),
+		net.JoinHostPort(net.IP(k.DestIp[:]).String(), strconv.Itoa(int(k.DestPort))))
+}
+
+// tcpFlowKey returns the flow key of a decoded TCP packet.
+func tcpFlowKey(p *Packet) (k TcpFlowKey, ok bool) {
+	if p.Tcphdr.DataOffset < 5 {
+		return k, false
+	}
+	return ipFlowKey(p, IP_TCP, p.Tcphdr.SrcPort, p.Tcphdr.DestPort)
+}
+
+// ipFlowKey returns the flow key of a packet whose innermost IPv4 or IPv6
+// header carries proto. IPv4 addresses are stored in their IPv6-mapped form.
+func ipFlowKey(p *Packet, proto uint8, srcPort, destPort uint16) (k TcpFlowKey, ok bool) {
+	switch {
+	case p.Ip6hdr.Version == 6:
+		if p.Ip6hdr.Protocol != proto {
+			return k, false
+		}
+		copy(k.SrcIp[:], p.Ip6hdr.SrcIp)
+		copy(k.DestIp[:], p.Ip6hdr.DestIp)
+	case p.Iphdr.Version == 4:
+		if p.Iphdr.Protocol != proto {
+			return k, false
+		}
+		copy(k.SrcIp[:], net.IP(p.Iphdr.SrcIp).To16())
+		copy(k.DestIp[:], net.IP(p.Iphdr.DestIp).To16())
+	default:
+		return k, false
+	}
+	k.SrcPort = srcPort
+	k.DestPort = destPort
+	return k, true
+}
+
+// TcpFlow is the throughput estimate of one direction of a TCP connection.
+// Sequence state refers to the data sent in this direction; acknowledgements
+// and the window are taken from segments of the opposite direction.
+type TcpFlow struct {
+	Key TcpFlowKey
+
+	First time.Time // first segment sent
+	Last  time.Time // last segment sent
+
+	Synced   bool   // sequence space is known
+	SndUna   uint32 // oldest unacknowledged sequence number
+	SndNxt   uint32 // highest sequence number sent, plus one
+	Shift    uint8  // window scale used by this side's advertisements
+	WsOption bool   // this side offered window scaling in its SYN
+
+	BytesSent     uint64    // payload bytes, including retransmissions
+	Retransmitted uint64    // payload bytes at or below SndNxt
+	Missing       uint64    // sequence space never seen in the capture
+	BytesAcked    uint64    // bytes cumulatively acknowledged by the peer
+	FirstAck      time.Time // first acknowledgement from the peer
+	LastAck       time.Time // last acknowledgement of new data
+
+	Sacked      uint32 // bytes above SndUna selectively acknowledged
+	InFlight    uint32 // unacknowledged bytes still in the network
+	MaxInFlight uint32
+	Window      uint32 // receive window last advertised by the peer, scaled
+	MaxWindow   uint32
+}
+
+// Goodput returns the rate at which the peer acknowledged new data, in
+// bytes per second.
+func (f *TcpFlow) Goodput() float64 {
+	d := f.LastAck.Sub(f.FirstAck)
+	if d <= 0 {
+		return 0
+	}
+	return float64(f.BytesAcked) / d.Seconds()
+}
+
+func (f *TcpFlow) String() string {
+	return fmt.Sprintf("TCP %s SENT=%d RETRANS=%d ACKED=%d GOODPUT=%.0fB/s INFLIGHT=%d/%d WIN=%d",
+		f.Key, f.BytesSent, f.Retransmitted, f.BytesAcked, f.Goodput(),
+		f.InFlight, f.MaxInFlight, f.Window)
+}
+
+func (f *TcpFlow) updateInFlight() {
+	f.InFlight = 0
+	if f.Synced && seqGT(f.SndNxt, f.SndUna) {
+		if n := f.SndNxt - f.SndUna; n > f.Sacked {
+			f.InFlight = n - f.Sacked
+		}
+	}
+	if f.InFlight > f.MaxInFlight {
+		f.MaxInFlight = f.InFlight
+	}
+}
+
+// TcpThroughput estimates per-connection goodput and bytes in flight from
+// the TCP segments of a capture. Windows are scaled when both SYNs of a
+// connection were captured and carried the window scale option.
+type TcpThroughput struct {
+	Events *EventBus // receives EVENT_GAP for sequence space never seen
+
+	flows map[TcpFlowKey]*TcpFlow
+}
+
+// NewTcpThroughput creates an empty estimator.
+func NewTcpThroughput() *TcpThroughput {
+	return &TcpThroughput{flows: make(map[TcpFlowKey]*TcpFlow)}
+}
+
+func (t *TcpThroughput) flow(k TcpFlowKey) *TcpFlow {
+	f := t.flows[k]
+	if f == nil {
+		f = &TcpFlow{Key: k}
+		t.flows[k] = f
+	}
+	return f
+}
+
+// Flow returns the state of one direction, or nil if it was never seen.
+func (t *TcpThroughput) Flow(k TcpFlowKey) *TcpFlow {
+	return t.flows[k]
+}
+
+// Flows returns every direction seen so far, ordered by first segment.
+func (t *TcpThroughput) Flows() []*TcpFlow {
+	flows := make([]*TcpFlow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		if !flows[i].First.Equal(flows[j].First) {
+			return flows[i].First.Before(flows[j].First)
+		}
+		return flows[i].Key.String() < flows[j].Key.String()
+	})
+	return flows
+}
+
+// Add accounts a decoded packet. Packets that are not TCP are ignored.
+func (t *TcpThroughput) Add(p *Packet) {
+	k, ok := tcpFlowKey(p)
+	if !ok {
+		return
+	}
+	tcp := &p.Tcphdr
+	snd := t.flow(k)
+	rcv := t.flow(k.Reverse())
+
+	if snd.First.IsZero() {
+		snd.First = p.Time
+	}
+	snd.Last = p.Time
+
+	seq := tcp.Seq
+	n := uint32(len(p.Payload))
+	if tcp.Flags&TCP_SYN != 0 {
+		snd.Shift, snd.WsOption = tcp.WindowScale()
+		snd.Synced = true
+		snd.SndUna = seq + 1
+		snd.SndNxt = seq + 1
+		seq++
+	} else if !snd.Synced {
+		snd.Synced = true
+		snd.SndUna = seq
+		snd.SndNxt = seq
+	}
+	if tcp.Flags&TCP_FIN != 0 {
+		n++
+	}
+	if n > 0 {
+		end := seq + n
+		snd.BytesSent += uint64(len(p.Payload))
+		switch {
+		case !seqGT(end, snd.SndNxt):
+			snd.Retransmitted += uint64(n)
+		case seqLT(seq, snd.SndNxt):
+			snd.Retransmitted += uint64(snd.SndNxt - seq)
+		case seqGT(seq, snd.SndNxt):
+			snd.Missing += uint64(seq - snd.SndNxt)
+			t.Events.Publish(&Event{
+				Kind:    EVENT_GAP,
+				Source:  "tcp",
+				Time:    p.Time,
+				Count:   int64(seq - snd.SndNxt),
+				Message: k.String(),
+			})
+		}
+		if seqGT(end, snd.SndNxt) {
+			snd.SndNxt = end
+		}
+	}
+	snd.updateInFlight()
+
+	if tcp.Flags&TCP_ACK == 0 {
+		return
+	}
+	ack := tcp.Ack
+	if !rcv.Synced {
+		rcv.Synced = true
+		rcv.SndUna = ack
+		rcv.SndNxt = ack
+	}
+	if seqGT(ack, rcv.SndUna) {
+		rcv.BytesAcked += uint64(ack - rcv.SndUna)
+		rcv.SndUna = ack
+		if seqGT(ack, rcv.SndNxt) {
+			rcv.SndNxt = ack
+		}
+		if rcv.FirstAck.IsZero() {
+			rcv.FirstAck = p.Time
+		}
+		rcv.LastAck = p.Time
+	} else if rcv.FirstAck.IsZero() {
+		rcv.FirstAck = p.Time
+	}
+	rcv.Sacked = 0
+	for _, b := range tcp.Sack() {
+		left, right := b.Left, b.Right
+		if seqLT(left, rcv.SndUna) {
+			left = rcv.SndUna
+		}
+		if seqGT(right, rcv.SndNxt) {
+			right = rcv.SndNxt
+		}
+		if seqGT(right, left) {
+			rcv.Sacked += right - left
+		}
+	}
+	// Windows in SYN segments are never scaled.
+	win := uint32(tcp.Window)
+	if tcp.Flags&TCP_SYN == 0 && snd.WsOption && rcv.WsOption {
+		win <<= snd.Shift
+	}
+	rcv.Window = win
+	if win > rcv.MaxWindow {
+		rcv.MaxWindow = win
+	}
+	rcv.updateInFlight()
+}
+
+// seqLT and seqGT compare TCP sequence numbers modulo 2^32.
+func seqLT(a, b uint32) bool { return int32(a-b) < 0 }
+func seqGT(a, b uint32) bool { return int32(a-b) > 0 }
@@ -0,0 +1,162 @@
+package pcap
+
+import (
+	"io"
+	"sort"
+)
+
+// TcpStreams reassembles the payload of TCP connections into ordered byte
+// streams, one per direction, and writes them to the writers returned by
+// New, such as a FrameBuffer or a WebsocketStream.
+// Retransmitted and overlapping data is written once. Segments arriving
+// ahead of a hole are buffered until the hole is filled; when MaxPending
+// is exceeded, the hole is taken to be data the capture missed and is
+// skipped.
+type TcpStreams struct {
+	// New returns the writer receiving the data of a direction, when its
+	// first SYN or data segment is seen. It may return nil to ignore the
+	// direction.
+	New func(k TcpFlowKey) io.Writer
+
+	// MaxPending bounds the out-of-order bytes buffered per direction,
+	// 1MiB if zero.
+	MaxPending int
+	Skipped    uint64    // bytes never captured, skipped over
+	Events     *EventBus // receives EVENT_GAP for every hole skipped
+
+	streams map[TcpFlowKey]*tcpStream
+}
+
+type tcpSegment struct {
+	seq  uint32
+	data []byte
+}
+
+type tcpStream struct {
+	w       io.Writer
+	next    uint32       // sequence number of the next byte to write
+	pending []tcpSegment // out-of-order segments, by sequence number
+	size    int          // bytes in pending
+	fin     bool         // a FIN was seen, ending at end
+	end     uint32
+}
+
+// NewTcpStreams creates an empty reassembler writing to the writers
+// returned by fn.
+func NewTcpStreams(fn func(k TcpFlowKey) io.Writer) *TcpStreams {
+	return &TcpStreams{New: fn, streams: make(map[TcpFlowKey]*tcpStream)}
+}
+
+func (t *TcpStreams) maxPending() int {
+	if t.MaxPending > 0 {
+		return t.MaxPending
+	}
+	return 1 << 20
+}
+
+// Add accounts a decoded packet and writes the data it makes available.
+// Packets that are not TCP are ignored. A direction is forgotten after
+// its FIN was written, after a RST, and after its writer fails; the error
+// of the writer is returned.
+func (t *TcpStreams) Add(p *Packet) error {
+	k, ok := tcpFlowKey(p)
+	if !ok {
+		return nil
+	}
+	tcp := &p.Tcphdr
+	s := t.streams[k]
+	if s == nil {
+		if tcp.Flags&TCP_SYN == 0 && len(p.Payload) == 0 {
+			return nil
+		}
+		s = &tcpStream{next: tcp.Seq}
+		if tcp.Flags&TCP_SYN != 0 {
+			s.next++
+		}
+		if t.New != nil {
+			s.w = t.New(k)
+		}
+		t.streams[k] = s
+	}
+	if s.w == nil {
+		if tcp.Flags&(TCP_FIN|TCP_RST) != 0 {
+			delete(t.streams, k)
+		}
+		return nil
+	}
+	if tcp.Flags&TCP_RST != 0 {
+		delete(t.streams, k)
+		return nil
+	}
+	seq := tcp.Seq
+	if tcp.Flags&TCP_SYN != 0 {
+		seq++
+	}
+	if len(p.Payload) > 0 && seqGT(seq+uint32(len(p.Payload)), s.next) {
+		if seqGT(seq, s.next) {
+			s.insert(seq, append([]byte{}, p.Payload...))
+		} else if err := s.write(seq, p.Payload); err != nil {
+			delete(t.streams, k)
+			return err
+		}
+	}
+	if err := t.drain(k, s, p); err != nil {
+		delete(t.streams, k)
+		return err
+	}
+	if tcp.Flags&TCP_FIN != 0 {
+		s.fin, s.end = true, seq+uint32(len(p.Payload))
+	}
+	if s.fin && !seqLT(s.next, s.end) {
+		delete(t.streams, k)
+	}
+	return nil
+}
+
+// drain writes the pending segments that became contiguous, skipping the
+// hole before the first of them while too much is buffered.
+func (t *TcpStreams) drain(k TcpFlowKey, s *tcpStream, p *Packet) error {
+	for len(s.pending) > 0 {
+		first := s.pending[0]
+		if seqGT(first.seq, s.next) {
+			if s.size <= t.maxPending() {
+				return nil
+			}
+			gap := first.seq - s.next
+			t.Skipped += uint64(gap)
+			t.Events.Publish(&Event{
+				Kind:    EVENT_GAP,
+				Source:  "tcpstream",
+				Time:    p.Time,
+				Count:   int64(gap),
+				Message: k.String(),
+			})
+			s.next = first.seq
+		}
+		s.pending = s.pending[1:]
+		s.size -= len(first.data)
+		if seqGT(first.seq+uint32(len(first.data)), s.next) {
+			if err := s.write(first.seq, first.data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write writes the part of data, starting at seq, beyond s.next.
+func (s *tcpStream) write(seq uint32, data []byte) error {
+	data = data[s.next-seq:]
+	s.next += uint32(len(data))
+	_, err := s.w.Write(data)
+	return err
+}
+
+// insert buffers an out-of-order segment.
+func (s *tcpStream) insert(seq uint32, data []byte) {
+	i := sort.Search(len(s.pending), func(i int) bool { return !seqLT(s.pending[i].seq, seq) })
+	s.pending = append(s.pending, tcpSegment{})
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = tcpSegment{seq, data}
+	s.size += len(data)
+}
@@ -0,0 +1,195 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// tcpFrame returns a decoded packet of the connection from port 40000 to
+// port 502, or back when reply is set.
+func tcpFrame(t *testing.T, reply bool, seq uint32, flags uint8, payload string) *Packet {
+	t.Helper()
+	b := make([]byte, 20)
+	src, dst := uint16(40000), uint16(502)
+	if reply {
+		src, dst = dst, src
+	}
+	binary.BigEndian.PutUint16(b[0:], src)
+	binary.BigEndian.PutUint16(b[2:], dst)
+	binary.BigEndian.PutUint32(b[4:], seq)
+	b[12], b[13] = 5<<4, flags
+	p := &Packet{Data: ethernet(TYPE_IP, ipv4(IP_TCP, append(b, payload...)))}
+	if err := p.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+type tcpStep struct {
+	reply   bool
+	seq     uint32
+	flags   uint8
+	payload string
+}
+
+func TestTcpStreams(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxPending int
+		steps      []tcpStep
+		out, back  string
+		skipped    uint64
+		open       int // directions still tracked
+	}{
+		{"in order", 0, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{true, 700, TCP_SYN | TCP_ACK, ""},
+			{false, 101, TCP_ACK, "abc"},
+			{true, 701, TCP_ACK, "xy"},
+			{false, 104, TCP_ACK, "def"},
+		}, "abcdef", "xy", 0, 2},
+		{"out of order", 0, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{false, 107, TCP_ACK, "ghi"},
+			{false, 104, TCP_ACK, "def"},
+			{false, 101, TCP_ACK, "abc"},
+		}, "abcdefghi", "", 0, 1},
+		{"retransmission and overlap", 0, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{false, 101, TCP_ACK, "abc"},
+			{false, 101, TCP_ACK, "abc"},
+			{false, 102, TCP_ACK, "bcde"},
+			{false, 106, TCP_ACK, "fg"},
+			{false, 104, TCP_ACK, "def"},
+		}, "abcdefg", "", 0, 1},
+		{"overlapping pending segments", 0, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{false, 105, TCP_ACK, "efgh"},
+			{false, 103, TCP_ACK, "cdef"},
+			{false, 101, TCP_ACK, "ab"},
+		}, "abcdefgh", "", 0, 1},
+		{"mid-stream start", 0, []tcpStep{
+			{false, 5000, TCP_ACK, "abc"},
+			{false, 5003, TCP_ACK, "def"},
+		}, "abcdef", "", 0, 1},
+		{"sequence wraps", 0, []tcpStep{
+			{false, 0xfffffffe, TCP_SYN, ""},
+			{false, 0xffffffff, TCP_ACK, "ab"},
+			{false, 1, TCP_ACK, "cd"},
+		}, "abcd", "", 0, 1},
+		{"hole skipped past max pending", 4, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{false, 101, TCP_ACK, "ab"},
+			{false, 106, TCP_ACK, "fgh"},
+			{false, 109, TCP_ACK, "ij"},
+			{false, 103, TCP_ACK, "cde"},
+		}, "abfghij", "", 3, 1},
+		{"fin", 0, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{true, 700, TCP_SYN | TCP_ACK, ""},
+			{false, 101, TCP_ACK | TCP_FIN, "abc"},
+		}, "abc", "", 0, 1},
+		{"fin ahead of a hole", 0, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{false, 104, TCP_ACK | TCP_FIN, "def"},
+			{false, 101, TCP_ACK, "abc"},
+		}, "abcdef", "", 0, 0},
+		{"rst", 0, []tcpStep{
+			{false, 100, TCP_SYN, ""},
+			{true, 700, TCP_SYN | TCP_ACK, ""},
+			{false, 101, TCP_ACK, "abc"},
+			{true, 701, TCP_RST, ""},
+			{false, 104, TCP_RST, ""},
+		}, "abc", "", 0, 0},
+		{"data after rst starts a new stream", 0, []tcpStep{
+			{false, 101, TCP_ACK, "abc"},
+			{false, 104, TCP_RST, ""},
+			{false, 900, TCP_ACK, "xyz"},
+		}, "abcxyz", "", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, back bytes.Buffer
+			s := NewTcpStreams(func(k TcpFlowKey) io.Writer {
+				if k.SrcPort == 502 {
+					return &back
+				}
+				return &out
+			})
+			s.MaxPending = tt.maxPending
+			s.Events = NewEventBus()
+			var gaps int64
+			s.Events.Subscribe(func(e *Event) { gaps += e.Count }, EVENT_GAP)
+			for i, st := range tt.steps {
+				if err := s.Add(tcpFrame(t, st.reply, st.seq, st.flags, st.payload)); err != nil {
+					t.Fatalf("segment %d: %v", i, err)
+				}
+			}
+			if out.String() != tt.out || back.String() != tt.back {
+				t.Errorf("streams %q and %q, want %q and %q", out.String(), back.String(), tt.out, tt.back)
+			}
+			if s.Skipped != tt.skipped || gaps != int64(tt.skipped) {
+				t.Errorf("skipped %d with gap events of %d, want %d", s.Skipped, gaps, tt.skipped)
+			}
+			if len(s.streams) != tt.open {
+				t.Errorf("%d directions tracked, want %d", len(s.streams), tt.open)
+			}
+		})
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("full") }
+
+func TestTcpStreamsWriteError(t *testing.T) {
+	s := NewTcpStreams(func(TcpFlowKey) io.Writer { return failingWriter{} })
+	if err := s.Add(tcpFrame(t, false, 100, TCP_ACK, "abc")); err == nil {
+		t.Fatal("got no error from the writer")
+	}
+	if len(s.streams) != 0 {
+		t.Errorf("%d directions tracked after the error, want 0", len(s.streams))
+	}
+}
+
+func TestTcpStreamsFrames(t *testing.T) {
+	adu := func(unit byte, pdu string) string {
+		b := []byte{0, 1, 0, 0, 0, byte(1 + len(pdu)), unit}
+		return string(append(b, pdu...))
+	}
+	stream := adu(1, "\x03\x00\x00\x00\x02") + adu(2, "\x03\x00\x10\x00\x01") + adu(3, "\x06\x00\x01\x00\x07")
+	fb, err := NewFrameBuffer(ModbusFrameSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTcpStreams(func(TcpFlowKey) io.Writer { return fb })
+	var frames []string
+	// segments cut across frame boundaries, delivered out of order
+	cuts := []struct{ from, to int }{{0, 5}, {9, 20}, {5, 9}, {20, len(stream)}}
+	for _, c := range cuts {
+		if err := s.Add(tcpFrame(t, false, 1000+uint32(c.from), TCP_ACK, stream[c.from:c.to])); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			f, err := fb.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f == nil {
+				break
+			}
+			frames = append(frames, string(f))
+		}
+	}
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	for i, f := range frames {
+		if f[6] != byte(i+1) || f != stream[12*i:12*i+12] {
+			t.Errorf("frame %d: %x", i, f)
+		}
+	}
+}
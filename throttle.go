@@ -0,0 +1,130 @@
+package pcap
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ThrottledReader limits the rate of reads from an underlying reader with
+// a token bucket, so that scans of shared storage leave bandwidth to
+// others. Reads of up to the burst size are allowed at once, refilled at
+// the configured rate.
+type ThrottledReader struct {
+	r io.Reader
+
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottledReader creates a reader of at most bytesPerSec bytes per
+// second from r. The burst is one second of data if burst is zero.
+func NewThrottledReader(r io.Reader, bytesPerSec, burst int) *ThrottledReader {
+	t := &ThrottledReader{r: r}
+	t.SetRate(bytesPerSec, burst)
+	t.tokens = t.burst
+	return t
+}
+
+// SetRate changes the limits, which may be done while another goroutine
+// reads. A rate of zero or less removes the limit.
+func (t *ThrottledReader) SetRate(bytesPerSec, burst int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+	t.rate = float64(bytesPerSec)
+	t.burst = float64(burst)
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+}
+
+// take waits until n bytes may be read, for n up to the burst size, and
+// returns the number granted.
+func (t *ThrottledReader) take(n int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rate <= 0 {
+		return n
+	}
+	if float64(n) > t.burst {
+		n = int(t.burst)
+		if n < 1 {
+			n = 1
+		}
+	}
+	now := time.Now()
+	if !t.last.IsZero() {
+		t.tokens += now.Sub(t.last).Seconds() * t.rate
+		if t.tokens > t.burst {
+			t.tokens = t.burst
+		}
+	}
+	t.last = now
+	if need := float64(n) - t.tokens; need > 0 {
+		wait := time.Duration(need / t.rate * float64(time.Second))
+		t.mu.Unlock()
+		time.Sleep(wait)
+		t.mu.Lock()
+		t.tokens += time.Since(t.last).Seconds() * t.rate
+		t.last = time.Now()
+	}
+	t.tokens -= float64(n)
+	return n
+}
+
+// refund returns the tokens of bytes granted but not read.
+func (t *ThrottledReader) refund(n int) {
+	if n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	if t.rate > 0 {
+		t.tokens += float64(n)
+		if t.tokens > t.burst {
+			t.tokens = t.burst
+		}
+	}
+	t.mu.Unlock()
+}
+
+func (t *ThrottledReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return t.r.Read(p)
+	}
+	n := t.take(len(p))
+	read, err := t.r.Read(p[:n])
+	t.refund(n - read)
+	return read, err
+}
+
+// Seek seeks the underlying reader, without being throttled. It fails if
+// the underlying reader cannot seek.
+func (t *ThrottledReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := t.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("pcap: reader does not support seeking")
+	}
+	return s.Seek(offset, whence)
+}
+
+// SetRateLimit throttles further reads of the file to bytesPerSec, with
+// bursts of up to burst bytes, one second of data if zero. A rate of zero
+// or less removes the limit. Skipping over packets by seeking is not
+// throttled.
+func (r *Reader) SetRateLimit(bytesPerSec, burst int) {
+	if t, ok := r.buf.(*ThrottledReader); ok {
+		t.SetRate(bytesPerSec, burst)
+		return
+	}
+	if bytesPerSec <= 0 {
+		return
+	}
+	r.buf = NewThrottledReader(r.buf, bytesPerSec, burst)
+}
@@ -0,0 +1,60 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// TimestampField reads a timestamp embedded in the payload of decoded
+// packets, such as the send time stamped by an exchange. Its Time method
+// can be used as a Writer's TimeFunc:
+//
+//	w.TimeFunc = (&pcap.TimestampField{Offset: 5, Size: 6, SinceMidnight: true}).Time
+type TimestampField struct {
+	Offset int              // position of the field within Packet.Payload
+	Size   int              // width of the field: 1 to 8 bytes
+	Order  binary.ByteOrder // byte order of the field, big endian if nil
+	Unit   time.Duration    // what the field counts, nanoseconds if zero
+
+	// SinceMidnight is set when the field counts from midnight of the
+	// capture day in Location, UTC if nil, rather than from the Unix epoch.
+	// A result more than 12 hours from the capture time is taken to belong
+	// to the day before or after, as happens for packets sent just before
+	// midnight and captured just after it.
+	SinceMidnight bool
+	Location      *time.Location
+}
+
+// Time returns the timestamp in the payload of p, or the capture time of p
+// if the payload is too short to hold the field.
+func (f *TimestampField) Time(p *Packet) time.Time {
+	if f.Size < 1 || f.Size > 8 || f.Offset < 0 || len(p.Payload) < f.Offset+f.Size {
+		return p.Time
+	}
+	order := f.Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	unit := f.Unit
+	if unit == 0 {
+		unit = time.Nanosecond
+	}
+	v := schemaUint(p.Payload[f.Offset:f.Offset+f.Size], order)
+	d := time.Duration(v) * unit
+	if !f.SinceMidnight {
+		return time.Unix(0, int64(d))
+	}
+	loc := f.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t := p.Time.In(loc)
+	ts := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Add(d)
+	switch diff := ts.Sub(p.Time); {
+	case diff > 12*time.Hour:
+		ts = ts.AddDate(0, 0, -1)
+	case diff < -12*time.Hour:
+		ts = ts.AddDate(0, 0, 1)
+	}
+	return ts
+}
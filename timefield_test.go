@@ -0,0 +1,43 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestTimestampFieldSinceMidnight(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	day := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		loc      *time.Location
+		captured time.Time
+		field    time.Duration // value of the field
+		want     time.Time
+	}{
+		{"same day", nil, day.Add(10 * time.Hour), 10*time.Hour - time.Millisecond, day.Add(10*time.Hour - time.Millisecond)},
+		{"sent before midnight", nil, day.Add(time.Millisecond), 24*time.Hour - time.Millisecond, day.Add(-time.Millisecond)},
+		{"captured before midnight", nil, day.Add(-time.Millisecond), time.Millisecond, day.Add(time.Millisecond)},
+		{"half a day early", nil, day.Add(18 * time.Hour), 6 * time.Hour, day.Add(6 * time.Hour)},
+		{"sent before midnight in location", ny,
+			time.Date(2021, 3, 4, 0, 0, 1, 0, ny), 24*time.Hour - time.Second,
+			time.Date(2021, 3, 3, 23, 59, 59, 0, ny)},
+		{"captured before midnight before a DST change", ny,
+			time.Date(2021, 3, 13, 23, 59, 59, 0, ny), time.Second,
+			time.Date(2021, 3, 14, 0, 0, 1, 0, ny)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := make([]byte, 8)
+			binary.BigEndian.PutUint64(payload, uint64(tt.field/time.Microsecond))
+			f := &TimestampField{Size: 8, Unit: time.Microsecond, SinceMidnight: true, Location: tt.loc}
+			if got := f.Time(&Packet{Time: tt.captured, Payload: payload}); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
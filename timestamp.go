@@ -0,0 +1,75 @@
+package pcap
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timestamp is a packet timestamp as stored in a capture file: whole
+// seconds and a fraction counted in units of Resolution. Keeping the raw
+// fields lets a Writer of the same resolution reproduce them exactly, and
+// shows when a conversion to another resolution rounds.
+type Timestamp struct {
+	Sec        uint32
+	Frac       uint32
+	Resolution time.Duration // time.Microsecond or time.Nanosecond for pcap files
+
+	// Source tells where the time was taken. Classic pcap files have no
+	// field for it, neither in the file header nor in the records, so
+	// Reader reports TIMESTAMP_UNKNOWN. Capture sources that know it may
+	// set it; Convert and Writer keep it, see Writer.LastTimestamp.
+	Source TimestampSource
+}
+
+// TimestampSource is the clock a timestamp was taken from.
+type TimestampSource uint8
+
+const (
+	TIMESTAMP_UNKNOWN  TimestampSource = iota
+	TIMESTAMP_HOST                     // the host clock, when the packet was delivered
+	TIMESTAMP_HARDWARE                 // the network adapter, when the packet arrived
+)
+
+var timestampSourceNames = [...]string{"unknown", "host", "hardware"}
+
+func (s TimestampSource) String() string {
+	if int(s) < len(timestampSourceNames) {
+		return timestampSourceNames[s]
+	}
+	return fmt.Sprintf("TimestampSource(%d)", uint8(s))
+}
+
+// NewTimestamp returns the timestamp of t in the given resolution,
+// nanoseconds if zero, and whether it represents t exactly.
+func NewTimestamp(t time.Time, resolution time.Duration) (Timestamp, bool) {
+	if resolution <= 0 {
+		resolution = time.Nanosecond
+	}
+	ns := time.Duration(t.Nanosecond())
+	return Timestamp{
+		Sec:        uint32(t.Unix()),
+		Frac:       uint32(ns / resolution),
+		Resolution: resolution,
+	}, ns%resolution == 0
+}
+
+// IsZero reports whether the timestamp is unset.
+func (ts Timestamp) IsZero() bool { return ts.Resolution == 0 }
+
+// Time returns the timestamp as a time.Time.
+func (ts Timestamp) Time() time.Time {
+	return time.Unix(int64(ts.Sec), int64(ts.Frac)*int64(ts.Resolution))
+}
+
+// Convert returns the timestamp in another resolution, and whether the
+// conversion was exact. The source is kept.
+func (ts Timestamp) Convert(resolution time.Duration) (Timestamp, bool) {
+	if resolution == ts.Resolution {
+		return ts, true
+	}
+	ns := time.Duration(ts.Frac) * ts.Resolution
+	out := ts
+	out.Frac = uint32(ns / resolution)
+	out.Resolution = resolution
+	return out, ns%resolution == 0
+}
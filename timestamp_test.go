@@ -0,0 +1,59 @@
+package pcap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTimestampConvert(t *testing.T) {
+	tests := []struct {
+		in         Timestamp
+		resolution time.Duration
+		want       Timestamp
+		exact      bool
+	}{
+		{Timestamp{1, 123456, time.Microsecond, TIMESTAMP_HOST}, time.Nanosecond,
+			Timestamp{1, 123456000, time.Nanosecond, TIMESTAMP_HOST}, true},
+		{Timestamp{1, 123456789, time.Nanosecond, TIMESTAMP_HARDWARE}, time.Microsecond,
+			Timestamp{1, 123456, time.Microsecond, TIMESTAMP_HARDWARE}, false},
+		{Timestamp{1, 123456000, time.Nanosecond, TIMESTAMP_HARDWARE}, time.Microsecond,
+			Timestamp{1, 123456, time.Microsecond, TIMESTAMP_HARDWARE}, true},
+		{Timestamp{2, 5, time.Microsecond, TIMESTAMP_UNKNOWN}, time.Microsecond,
+			Timestamp{2, 5, time.Microsecond, TIMESTAMP_UNKNOWN}, true},
+	}
+	for _, tt := range tests {
+		got, exact := tt.in.Convert(tt.resolution)
+		if got != tt.want || exact != tt.exact {
+			t.Errorf("%+v to %v: got %+v %v, want %+v %v", tt.in, tt.resolution, got, exact, tt.want, tt.exact)
+		}
+	}
+}
+
+func TestWriterKeepsTimestampSource(t *testing.T) {
+	at := time.Unix(1600000000, 123456789)
+	ts, _ := NewTimestamp(at, time.Nanosecond)
+	ts.Source = TIMESTAMP_HARDWARE
+	tests := []struct {
+		name string
+		pkt  Packet
+		want TimestampSource
+	}{
+		{"packet timestamp", Packet{Time: at, Timestamp: ts}, TIMESTAMP_HARDWARE},
+		{"time changed", Packet{Time: at.Add(time.Second), Timestamp: ts}, TIMESTAMP_UNKNOWN},
+		{"no timestamp", Packet{Time: at}, TIMESTAMP_UNKNOWN},
+	}
+	for _, tt := range tests {
+		w, err := NewWriter(&bytes.Buffer{}, &FileHeader{MagicNumber: TCPDUMP_MAGIC, VersionMajor: 2, VersionMinor: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(&tt.pkt); err != nil {
+			t.Fatal(err)
+		}
+		last := w.LastTimestamp()
+		if last.Source != tt.want || last.Resolution != time.Microsecond || last.Frac != 123456 {
+			t.Errorf("%s: last timestamp %+v, want source %v", tt.name, last, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pcap
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// TruncateLimits bound the prefix of a capture kept by Truncate. Zero
+// values mean no limit.
+type TruncateLimits struct {
+	Packets int       // packets kept
+	Bytes   int64     // size of the output, file headers included; the first header is always written
+	End     time.Time // the prefix ends before the first packet captured at or after End
+}
+
+// TruncateResult reports what Truncate wrote.
+type TruncateResult struct {
+	Packets int
+	Bytes   int64
+}
+
+// Truncate copies the longest prefix of the capture in src that fits the
+// limits to dst, in one pass. The output keeps the magic number, byte
+// order and timestamp precision of the input, so that its file header and
+// packet records are byte for byte those of the input. Concatenated input
+// files are copied with their own file headers.
+func Truncate(dst io.Writer, src io.Reader, limits TruncateLimits) (TruncateResult, error) {
+	var res TruncateResult
+	r, err := NewReader(src)
+	if err != nil {
+		return res, err
+	}
+	out := bufio.NewWriterSize(dst, 64<<10)
+	w, err := NewPreservingWriter(out, &r.Info)
+	if err != nil {
+		return res, err
+	}
+	segments := len(r.Segments)
+	var done int64 // bytes written before w
+	for limits.Packets <= 0 || res.Packets < limits.Packets {
+		p := r.Next()
+		if p == nil {
+			break
+		}
+		if !limits.End.IsZero() && !p.Time.Before(limits.End) {
+			p.Free()
+			break
+		}
+		segment := len(r.Segments) != segments
+		size := done + w.Offset() + 16 + int64(len(p.Data))
+		if segment {
+			size += 24
+		}
+		if limits.Bytes > 0 && size > limits.Bytes {
+			p.Free()
+			break
+		}
+		if segment {
+			segments = len(r.Segments)
+			done += w.Offset()
+			if w, err = NewPreservingWriter(out, &r.Info); err != nil {
+				p.Free()
+				return res, err
+			}
+		}
+		err := w.Write(p)
+		p.Free()
+		if err != nil {
+			return res, err
+		}
+		res.Packets++
+	}
+	res.Bytes = done + w.Offset()
+	if err := r.Err(); err != nil {
+		return res, err
+	}
+	return res, out.Flush()
+}
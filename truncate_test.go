@@ -0,0 +1,74 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestTruncate(t *testing.T) {
+	t0 := time.Date(2021, 3, 4, 5, 6, 7, 123456000, time.UTC)
+	times := []time.Time{t0, t0.Add(time.Second), t0.Add(2 * time.Second), t0.Add(3 * time.Second), t0.Add(4 * time.Second)}
+	le := segmentFile{binary.LittleEndian, time.Microsecond, 1, times}
+	be := segmentFile{binary.BigEndian, time.Nanosecond, 1, times}
+	const record = 16 + 4 // record header and the data written by segmentFile
+
+	tests := []struct {
+		name    string
+		files   []segmentFile
+		limits  TruncateLimits
+		packets int
+		bytes   int64
+	}{
+		{"no limits", []segmentFile{le}, TruncateLimits{}, 5, 24 + 5*record},
+		{"packets", []segmentFile{le}, TruncateLimits{Packets: 3}, 3, 24 + 3*record},
+		{"packets past end", []segmentFile{le}, TruncateLimits{Packets: 10}, 5, 24 + 5*record},
+		{"bytes on a record boundary", []segmentFile{le}, TruncateLimits{Bytes: 24 + 2*record}, 2, 24 + 2*record},
+		{"bytes within a record", []segmentFile{le}, TruncateLimits{Bytes: 24 + 3*record - 1}, 2, 24 + 2*record},
+		{"bytes below the file header", []segmentFile{le}, TruncateLimits{Bytes: 10}, 0, 24},
+		{"end at a packet", []segmentFile{le}, TruncateLimits{End: times[3]}, 3, 24 + 3*record},
+		{"end between packets", []segmentFile{le}, TruncateLimits{End: times[1].Add(time.Nanosecond)}, 2, 24 + 2*record},
+		{"end before the first packet", []segmentFile{le}, TruncateLimits{End: t0.Add(-time.Hour)}, 0, 24},
+		{"end after the last packet", []segmentFile{le}, TruncateLimits{End: t0.Add(time.Hour)}, 5, 24 + 5*record},
+		{"packets below bytes and end", []segmentFile{le}, TruncateLimits{Packets: 1, Bytes: 1 << 20, End: times[4]}, 1, 24 + record},
+		{"bytes below packets and end", []segmentFile{le}, TruncateLimits{Packets: 4, Bytes: 24 + 2*record, End: times[4]}, 2, 24 + 2*record},
+		{"end below packets and bytes", []segmentFile{le}, TruncateLimits{Packets: 4, Bytes: 1 << 20, End: times[2]}, 2, 24 + 2*record},
+		{"big endian nanoseconds", []segmentFile{be}, TruncateLimits{Packets: 2}, 2, 24 + 2*record},
+		{"concatenated", []segmentFile{le, be}, TruncateLimits{Packets: 7}, 7, 24 + 5*record + 24 + 2*record},
+		{"bytes include the second file header", []segmentFile{le, be}, TruncateLimits{Bytes: 24 + 5*record + 24 + record - 1}, 5, 24 + 5*record},
+		{"bytes fit the second file header", []segmentFile{le, be}, TruncateLimits{Bytes: 24 + 5*record + 24 + record}, 6, 24 + 5*record + 24 + record},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in []byte
+			for _, f := range tt.files {
+				in = append(in, f.bytes(t)...)
+			}
+			var out bytes.Buffer
+			res, err := Truncate(&out, bytes.NewReader(in), tt.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Packets != tt.packets || res.Bytes != tt.bytes {
+				t.Errorf("wrote %d packets and %d bytes, want %d and %d", res.Packets, res.Bytes, tt.packets, tt.bytes)
+			}
+			if int64(out.Len()) != res.Bytes {
+				t.Errorf("output is %d bytes, result says %d", out.Len(), res.Bytes)
+			}
+			if !bytes.HasPrefix(in, out.Bytes()) {
+				t.Errorf("output is not a prefix of the input")
+			}
+			if tt.limits.Bytes > 0 && res.Bytes > tt.limits.Bytes && res.Packets > 0 {
+				t.Errorf("output of %d bytes exceeds the limit of %d", res.Bytes, tt.limits.Bytes)
+			}
+		})
+	}
+}
+
+func TestTruncateBadInput(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := Truncate(&out, bytes.NewReader([]byte("not a pcap file at all..")), TruncateLimits{}); err == nil {
+		t.Error("got no error for a bad magic number")
+	}
+}
@@ -0,0 +1,60 @@
+package pcap
+
+import "encoding/binary"
+
+// UDP port of Teredo servers, RFC 4380.
+const TEREDO_PORT = 3544
+
+// Tunnel holds the outer headers of an encapsulated packet. Its inner
+// packet is decoded into the headers of the Packet, so that Iphdr or
+// Ip6hdr, and the transport headers, always describe the innermost packet.
+type Tunnel struct {
+	Protocol uint8 // IP_INIP, IP_IPV6, or IP_UDP for Teredo
+	Iphdr    Iphdr // zero if the outer header is IPv6
+	Ip6hdr   Ip6hdr
+	Udphdr   Udphdr // Teredo only
+}
+
+// decodeTunnel pushes the current headers onto Tunnels and decodes the
+// IP packet in the payload, if it has the expected version.
+func (p *Packet) decodeTunnel(proto uint8, version uint8) {
+	pkt := p.Payload
+	if len(pkt) == 0 || pkt[0]>>4 != version {
+		return
+	}
+	t := Tunnel{Protocol: proto, Iphdr: p.Iphdr, Ip6hdr: p.Ip6hdr}
+	if proto == IP_UDP {
+		t.Udphdr = p.Udphdr
+		p.Udphdr = Udphdr{}
+	}
+	p.Tunnels = append(p.Tunnels, t)
+	p.Iphdr = Iphdr{}
+	p.Ip6hdr = Ip6hdr{}
+	if version == 4 {
+		p.decodeIp()
+	} else {
+		p.decodeIp6()
+	}
+}
+
+// decodeTeredo decodes IPv6 carried over UDP, after the optional
+// authentication and origin indications. Payloads that do not hold exactly
+// one IPv6 packet are left undecoded.
+func (p *Packet) decodeTeredo() {
+	pkt := p.Payload
+	if len(pkt) >= 4 && binary.BigEndian.Uint16(pkt[0:2]) == 0x0001 {
+		n := 4 + int(pkt[2]) + int(pkt[3]) + 9 // nonce and confirmation byte
+		if n > len(pkt) {
+			return
+		}
+		pkt = pkt[n:]
+	}
+	if len(pkt) >= 8 && binary.BigEndian.Uint16(pkt[0:2]) == 0x0000 {
+		pkt = pkt[8:]
+	}
+	if len(pkt) < 40 || pkt[0]>>4 != 6 || 40+int(binary.BigEndian.Uint16(pkt[4:6])) != len(pkt) {
+		return
+	}
+	p.Payload = pkt
+	p.decodeTunnel(IP_UDP, 6)
+}
@@ -0,0 +1,321 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WebSocket opcodes, RFC 6455.
+const (
+	WS_CONTINUATION = 0x0
+	WS_TEXT         = 0x1
+	WS_BINARY       = 0x2
+	WS_CLOSE        = 0x8
+	WS_PING         = 0x9
+	WS_PONG         = 0xA
+)
+
+// WS_RSV1 is the reserved bit set on the first frame of a message
+// compressed with permessage-deflate, RFC 7692.
+const WS_RSV1 = 0x4
+
+var (
+	ErrNotWebsocket = errors.New("pcap: not a websocket upgrade")
+
+	errWebsocket = errors.New("pcap: malformed websocket frame")
+)
+
+var wsOpcodes = map[uint8]string{
+	WS_CONTINUATION: "CONTINUATION", WS_TEXT: "TEXT", WS_BINARY: "BINARY",
+	WS_CLOSE: "CLOSE", WS_PING: "PING", WS_PONG: "PONG",
+}
+
+// WebsocketFrame is a WebSocket frame. Payload is unmasked.
+type WebsocketFrame struct {
+	Fin     bool
+	Rsv     uint8 // reserved bits RSV1-3, see WS_RSV1
+	Opcode  uint8 // see WS_*
+	Masked  bool  // set on frames sent by the client
+	MaskKey [4]byte
+	Payload []byte
+}
+
+// Control reports whether the frame is a close, ping or pong frame.
+func (f *WebsocketFrame) Control() bool { return f.Opcode&0x8 != 0 }
+
+// Compressed reports whether RSV1 is set, which marks a message compressed
+// with permessage-deflate.
+func (f *WebsocketFrame) Compressed() bool { return f.Rsv&WS_RSV1 != 0 }
+
+func (f *WebsocketFrame) String() string {
+	name, ok := wsOpcodes[f.Opcode]
+	if !ok {
+		name = fmt.Sprintf("opcode=%#x", f.Opcode)
+	}
+	s := fmt.Sprintf("WS %s LEN=%d", name, len(f.Payload))
+	if f.Fin {
+		s += " fin"
+	}
+	if f.Masked {
+		s += " masked"
+	}
+	if f.Compressed() {
+		s += " compressed"
+	}
+	return s
+}
+
+// DecodeWebsocketFrame decodes the frame at the start of data and returns
+// the number of bytes it occupies, or ErrShortFrame if the frame is
+// incomplete. A masked payload is unmasked into a new slice; an unmasked
+// payload shares the memory of data.
+func DecodeWebsocketFrame(data []byte) (*WebsocketFrame, int, error) {
+	f, n, length, err := decodeWebsocketHeader(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if uint64(len(data)-n) < length {
+		return nil, 0, ErrShortFrame
+	}
+	payload := data[n : n+int(length)]
+	n += int(length)
+	if f.Masked {
+		unmasked := make([]byte, len(payload))
+		for i, c := range payload {
+			unmasked[i] = c ^ f.MaskKey[i&3]
+		}
+		payload = unmasked
+	}
+	f.Payload = payload
+	return f, n, nil
+}
+
+// decodeWebsocketHeader decodes the frame header at the start of data and
+// returns its length and the payload length it declares.
+func decodeWebsocketHeader(data []byte) (*WebsocketFrame, int, uint64, error) {
+	if len(data) < 2 {
+		return nil, 0, 0, ErrShortFrame
+	}
+	f := &WebsocketFrame{
+		Fin:    data[0]&0x80 != 0,
+		Rsv:    data[0] >> 4 & 0x7,
+		Opcode: data[0] & 0xF,
+		Masked: data[1]&0x80 != 0,
+	}
+	n := 2
+	length := uint64(data[1] & 0x7F)
+	switch length {
+	case 126:
+		if len(data) < n+2 {
+			return nil, 0, 0, ErrShortFrame
+		}
+		length = uint64(binary.BigEndian.Uint16(data[n:]))
+		n += 2
+	case 127:
+		if len(data) < n+8 {
+			return nil, 0, 0, ErrShortFrame
+		}
+		length = binary.BigEndian.Uint64(data[n:])
+		n += 8
+		if length>>63 != 0 {
+			return nil, 0, 0, errWebsocket
+		}
+	}
+	if f.Control() && (length > 125 || !f.Fin) {
+		return nil, 0, 0, errWebsocket
+	}
+	if f.Masked {
+		if len(data) < n+4 {
+			return nil, 0, 0, ErrShortFrame
+		}
+		copy(f.MaskKey[:], data[n:n+4])
+		n += 4
+	}
+	return f, n, length, nil
+}
+
+// IsWebsocketUpgrade reports whether data starts with a complete HTTP
+// request asking for a WebSocket upgrade, or with the 101 response
+// accepting one. It is false until the whole header block has arrived.
+func IsWebsocketUpgrade(data []byte) bool {
+	_, err := websocketHandshake(data)
+	return err == nil
+}
+
+// websocketHandshake returns the length of the HTTP upgrade request or
+// response at the start of data.
+func websocketHandshake(data []byte) (int, error) {
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	head := data
+	if end >= 0 {
+		head = data[:end]
+	}
+	lines := strings.Split(string(head), "\r\n")
+	status := lines[0]
+	switch {
+	case strings.HasPrefix(status, "GET "):
+	case strings.HasPrefix(status, "HTTP/1.1 101"):
+	default:
+		if end < 0 && len(status) < len("HTTP/1.1 101") &&
+			(strings.HasPrefix("GET ", status) || strings.HasPrefix("HTTP/1.1 101", status)) {
+			return 0, ErrShortFrame
+		}
+		return 0, ErrNotWebsocket
+	}
+	if end < 0 {
+		return 0, ErrShortFrame
+	}
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		value := strings.ToLower(strings.TrimSpace(line[i+1:]))
+		if strings.EqualFold(name, "upgrade") && strings.Contains(value, "websocket") {
+			return end + 4, nil
+		}
+	}
+	return 0, ErrNotWebsocket
+}
+
+// WebsocketMessage is a reassembled data message, or a control frame.
+type WebsocketMessage struct {
+	Opcode     uint8 // opcode of the first frame
+	Compressed bool  // RSV1 was set on the first frame
+	Frames     int
+	Data       []byte
+}
+
+func (m *WebsocketMessage) String() string {
+	name, ok := wsOpcodes[m.Opcode]
+	if !ok {
+		name = fmt.Sprintf("opcode=%#x", m.Opcode)
+	}
+	s := fmt.Sprintf("WS %s LEN=%d FRAMES=%d", name, len(m.Data), m.Frames)
+	if m.Compressed {
+		s += " compressed"
+	}
+	return s
+}
+
+// WebsocketStream collects one direction of a WebSocket connection, for
+// example as a writer of TcpStreams, and hands out messages. Control
+// frames sent between the fragments of a message are returned as they
+// arrive.
+type WebsocketStream struct {
+	MaxMessage int // longest reassembled message, 16MiB if zero
+
+	handshake bool // the HTTP upgrade has not been seen yet
+	buf       streamBuffer
+	msg       *WebsocketMessage // message awaiting continuation frames
+	skip      uint64            // bytes of an oversized frame still to discard
+	drop      bool              // the rest of an oversized message is discarded
+}
+
+// NewWebsocketStream creates an empty stream. If handshake is set, the
+// stream must start with the HTTP upgrade request or response, which is
+// skipped, and Next returns ErrNotWebsocket if it is anything else.
+func NewWebsocketStream(handshake bool) *WebsocketStream {
+	return &WebsocketStream{handshake: handshake}
+}
+
+func (s *WebsocketStream) maxMessage() int {
+	if s.MaxMessage > 0 {
+		return s.MaxMessage
+	}
+	return 16 << 20
+}
+
+// Write appends stream data.
+func (s *WebsocketStream) Write(data []byte) (int, error) {
+	s.buf.write(data)
+	return len(data), nil
+}
+
+// Next returns the next complete message or control frame, or nil if more
+// data is needed. After ErrFrameTooLong, the oversized frame and the rest
+// of its message are discarded as they arrive, and Next may be called
+// again.
+func (s *WebsocketStream) Next() (*WebsocketMessage, error) {
+	if s.handshake {
+		n, err := websocketHandshake(s.buf.bytes())
+		if err == ErrShortFrame {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.buf.consume(n)
+		s.handshake = false
+	}
+	for {
+		if s.skip > 0 {
+			n := uint64(len(s.buf.bytes()))
+			if n > s.skip {
+				n = s.skip
+			}
+			s.buf.consume(int(n))
+			s.skip -= n
+			if s.skip > 0 {
+				return nil, nil
+			}
+		}
+		h, hl, length, err := decodeWebsocketHeader(s.buf.bytes())
+		if err == ErrShortFrame {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pending := 0
+		if s.msg != nil {
+			pending = len(s.msg.Data)
+		}
+		if length > uint64(s.maxMessage()-pending) {
+			if !h.Control() {
+				s.msg = nil
+				s.drop = !h.Fin
+			}
+			s.skip = uint64(hl) + length
+			return nil, ErrFrameTooLong
+		}
+		f, n, err := DecodeWebsocketFrame(s.buf.bytes())
+		if err == ErrShortFrame {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.buf.consume(n)
+		if f.Control() {
+			data := append([]byte{}, f.Payload...)
+			return &WebsocketMessage{Opcode: f.Opcode, Frames: 1, Data: data}, nil
+		}
+		if f.Opcode == WS_CONTINUATION && s.drop {
+			s.drop = !f.Fin
+			continue
+		}
+		s.drop = false
+		if f.Opcode == WS_CONTINUATION {
+			if s.msg == nil {
+				return nil, errWebsocket
+			}
+		} else {
+			if s.msg != nil {
+				return nil, errWebsocket
+			}
+			s.msg = &WebsocketMessage{Opcode: f.Opcode, Compressed: f.Compressed()}
+		}
+		m := s.msg
+		m.Data = append(m.Data, f.Payload...)
+		m.Frames++
+		if f.Fin {
+			s.msg = nil
+			return m, nil
+		}
+	}
+}
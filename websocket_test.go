@@ -0,0 +1,95 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// wsFrame returns an unmasked frame.
+func wsFrame(fin bool, opcode uint8, payload string) []byte {
+	b := []byte{opcode, 0}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b[1] = byte(n)
+	case n < 1<<16:
+		b[1] = 126
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[2:], uint16(n))
+	default:
+		b[1] = 127
+		b = append(b, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(b[2:], uint64(n))
+	}
+	return append(b, payload...)
+}
+
+func TestWebsocketStreamFrameTooLong(t *testing.T) {
+	big := string(make([]byte, 300))
+	tests := []struct {
+		name   string
+		frames [][]byte
+		chunk  int      // bytes written between calls to Next
+		want   []string // data of the messages returned, "!" for ErrFrameTooLong
+	}{
+		{"oversized frame skipped", [][]byte{
+			wsFrame(true, WS_TEXT, "a"), wsFrame(true, WS_BINARY, big), wsFrame(true, WS_TEXT, "b"),
+		}, 1 << 10, []string{"a", "!", "b"}},
+		{"oversized frame arriving in pieces", [][]byte{
+			wsFrame(true, WS_BINARY, big), wsFrame(true, WS_TEXT, "b"),
+		}, 7, []string{"!", "b"}},
+		{"rest of the message dropped", [][]byte{
+			wsFrame(false, WS_TEXT, big), wsFrame(true, WS_PING, "p"),
+			wsFrame(false, WS_CONTINUATION, "x"), wsFrame(true, WS_CONTINUATION, "y"),
+			wsFrame(true, WS_TEXT, "b"),
+		}, 1 << 10, []string{"!", "p", "b"}},
+		{"message grown past the limit", [][]byte{
+			wsFrame(false, WS_TEXT, big[:200]), wsFrame(false, WS_CONTINUATION, big[:100]),
+			wsFrame(true, WS_CONTINUATION, "y"), wsFrame(true, WS_TEXT, "b"),
+		}, 1 << 10, []string{"!", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream []byte
+			for _, f := range tt.frames {
+				stream = append(stream, f...)
+			}
+			s := NewWebsocketStream(false)
+			s.MaxMessage = 256
+			var got []string
+			for len(stream) > 0 {
+				n := tt.chunk
+				if n > len(stream) {
+					n = len(stream)
+				}
+				s.Write(stream[:n])
+				stream = stream[n:]
+				for {
+					m, err := s.Next()
+					if err == ErrFrameTooLong {
+						got = append(got, "!")
+						continue
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if m == nil {
+						break
+					}
+					got = append(got, string(m.Data))
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}